@@ -2,170 +2,509 @@ package amqpx
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/shanluzhineng/amqpx/eventbus"
 	"github.com/shanluzhineng/configurationx/options/rabbitmq"
 )
 
-var (
-	_abqpClient *AMQPClient
-)
-
+// AMQPClient is safe for concurrent use, access to each channel is serialized
 type AMQPClient struct {
 	options *rabbitmq.DialOptions
 
-	isConnected bool
+	// connection used for consuming and declaring
+	consumeConn *amqpConnection
+	// connection used for publishing, it is same as consumeConn unless separate connections enabled
+	publishConn         *amqpConnection
+	separateConnections bool
+
+	reconnectInterval    time.Duration
+	maxReconnectInterval time.Duration
+
+	// cluster nodes, they are tried in order or shuffled when dial
+	endpoints        []string
+	shuffleEndpoints bool
+
+	tlsOptions        *TLSOptions
+	tlsConfig         *tls.Config
+	connectionOptions *ConnectionOptions
+
+	credentialsProvider CredentialsProvider
 
-	conn    *amqp.Connection
-	channel *amqp.Channel
+	// unix nano of the last successful publishing
+	lastPublishAt atomic.Int64
+
+	// lifecycle events are published on it when not nil
+	eventBus eventbus.Bus
+
+	// handlers invoked when connection blocked or unblocked
+	blockedHandlers   []func(connection string, blocking amqp.Blocking)
+	connectedHandlers []func(connection string)
+	handlersLock      sync.RWMutex
+
+	// channels created by GetNewChannel, they will be closed when client close
+	channels     map[*amqp.Channel]struct{}
+	channelsLock sync.Mutex
+	// serialize access to each channel, key is *amqp.Channel, value is *sync.Mutex
+	channelLocks sync.Map
+
+	// channel pools used for publishing
+	channelPoolSize int
+	publishPool     *channelPool
+	confirmPool     *channelPool
+	poolLock        sync.Mutex
 }
 
 type WithChannel struct {
 	Channel *amqp.Channel
 }
 
+// ErrBlocked returned when publishing on a connection blocked by broker
+var ErrBlocked = errors.New("connection is blocked by broker")
+
+// ErrQueueNotFound returned when the queue does not exist
+var ErrQueueNotFound = errors.New("queue not found")
+
+// ErrNotConnected returned when publishing but the connection cannot be established
+var ErrNotConnected = errors.New("connection is not established")
+
+// ErrNacked returned when a message is negatively acknowledged by broker in confirm mode
+var ErrNacked = errors.New("message is nacked by broker")
+
 // new a AMQPClient
-func NewAMQPClient(options *rabbitmq.DialOptions) (*AMQPClient, error) {
-	if options.RawUrl == "" {
+//
+// options.RawUrl can be a comma separated list of cluster node urls
+func NewAMQPClient(options *rabbitmq.DialOptions, opts ...ClientOption) (*AMQPClient, error) {
+	client := &AMQPClient{
+		options:              options,
+		channels:             make(map[*amqp.Channel]struct{}),
+		channelPoolSize:      _defaultChannelPoolSize,
+		reconnectInterval:    _defaultReconnectInterval,
+		maxReconnectInterval: _defaultMaxReconnectInterval,
+		connectionOptions:    &ConnectionOptions{},
+	}
+	client.endpoints = splitEndpoints(options.RawUrl)
+	for _, eachOpt := range opts {
+		eachOpt(client)
+	}
+	if len(client.endpoints) <= 0 {
 		return nil, fmt.Errorf("options.RawUrl value is empty")
 	}
-	client := &AMQPClient{
-		options: options,
+	if client.tlsOptions != nil {
+		// amqp applies TLS only to amqps url, other endpoints would connect in plain text
+		for _, eachEndpoint := range client.endpoints {
+			if !strings.HasPrefix(strings.ToLower(eachEndpoint), "amqps://") {
+				return nil, fmt.Errorf("endpoint %s should use amqps scheme when TLS is enabled", redactEndpoint(eachEndpoint))
+			}
+		}
+		tlsConfig, err := client.tlsOptions.BuildTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		client.tlsConfig = tlsConfig
+	}
+	client.consumeConn = newAMQPConnection("consumer", client)
+	client.publishConn = client.consumeConn
+	if client.separateConnections {
+		client.publishConn = newAMQPConnection("publisher", client)
 	}
 	return client, nil
 }
 
-// set Global client
+// set Global client, it is registed as the default client
 func GlobalABQPClient(abqpClient *AMQPClient) {
-	_abqpClient = abqpClient
+	Register(DefaultClientName, abqpClient)
 }
 
 // Get global *AMQPClient Instance, you can use GlobalABQPClient methods to set this value
 func Client() *AMQPClient {
-	return _abqpClient
+	return Default()
 }
 
 // connect to amqp server and create channel
 func (c *AMQPClient) Connect() error {
-	if c.isConnected {
-		return nil
-	}
-
-	conn, err := amqp.Dial(c.options.RawUrl)
+	err := c.consumeConn.open()
 	if err != nil {
 		return err
 	}
-	ch, err := conn.Channel()
-	if err != nil {
-		return err
+	if c.publishConn != c.consumeConn {
+		return c.publishConn.open()
 	}
-	c.conn = conn
-	c.channel = ch
-
-	c.isConnected = true
-
 	return nil
 }
 
 // get a new channel from amqp
 func (c *AMQPClient) GetNewChannel() (*amqp.Channel, error) {
-	err := c.ensureConnect()
+	ch, err := c.consumeConn.newChannel()
 	if err != nil {
 		return nil, err
 	}
-	return c.conn.Channel()
+	c.trackChannel(ch)
+	return ch, nil
 }
 
-// Close client
+// get a new channel used for publishing, it is created from the publisher connection
+// when separate connections enabled
+func (c *AMQPClient) GetNewPublishChannel() (*amqp.Channel, error) {
+	ch, err := c.publishConn.newChannel()
+	if err != nil {
+		return nil, err
+	}
+	c.trackChannel(ch)
+	return ch, nil
+}
+
+// Close client, all channels created by client will be closed before the connection
 func (c *AMQPClient) Close() error {
-	if c.conn == nil || c.conn.IsClosed() {
-		return nil
+	for _, eachChannel := range c.takeChannels() {
+		if eachChannel.IsClosed() {
+			continue
+		}
+		err := eachChannel.Close()
+		if err != nil {
+			return err
+		}
 	}
-	if c.channel != nil {
-		err := c.channel.Close()
+	if c.publishConn != c.consumeConn {
+		err := c.publishConn.close()
 		if err != nil {
 			return err
 		}
 	}
-	err := c.conn.Close()
+	return c.consumeConn.close()
+}
+
+func (c *AMQPClient) IsConnected() bool {
+	return c.consumeConn.connected() && c.publishConn.connected()
+}
+
+// is the publishing connection blocked by broker flow control(connection.blocked),
+// it happens when broker hits a memory or disk alarm
+func (c *AMQPClient) IsBlocked() bool {
+	blocked, _ := c.publishConn.blocked()
+	return blocked
+}
+
+// register handler that will be invoked when a connection becomes blocked or unblocked,
+// connection parameter is the name of connection, consumer or publisher
+func (c *AMQPClient) OnBlocked(fn func(connection string, blocking amqp.Blocking)) {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	c.blockedHandlers = append(c.blockedHandlers, fn)
+}
+
+// register handler that will be invoked when a connection is established or recovered,
+// connection parameter is the name of connection, consumer or publisher
+func (c *AMQPClient) OnConnected(fn func(connection string)) {
+	c.handlersLock.Lock()
+	defer c.handlersLock.Unlock()
+	c.connectedHandlers = append(c.connectedHandlers, fn)
+}
+
+// get the event bus that lifecycle events are published on, nil if not set
+func (c *AMQPClient) EventBus() eventbus.Bus {
+	return c.eventBus
+}
+
+// wait until the publishing connection is unblocked or ctx is done
+func (c *AMQPClient) WaitUnblocked(ctx context.Context) error {
+	return c.publishConn.waitUnblocked(ctx)
+}
+
+func (c *AMQPClient) notifyBlocked(connection string, blocking amqp.Blocking) {
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Printf("AMQPClient.notifyBlocked panic when notify blocked handler, panic: %v", p)
+		}
+	}()
+	c.handlersLock.RLock()
+	handlers := make([]func(connection string, blocking amqp.Blocking), len(c.blockedHandlers))
+	copy(handlers, c.blockedHandlers)
+	c.handlersLock.RUnlock()
+	for _, eachHandler := range handlers {
+		eachHandler(connection, blocking)
+	}
+}
+
+func (c *AMQPClient) notifyConnected(event *ConnectionEvent) {
+	c.publishEvent(Event_Connected, event)
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Printf("AMQPClient.notifyConnected panic when notify connected handler, panic: %v", p)
+		}
+	}()
+	c.handlersLock.RLock()
+	handlers := make([]func(connection string), len(c.connectedHandlers))
+	copy(handlers, c.connectedHandlers)
+	c.handlersLock.RUnlock()
+	for _, eachHandler := range handlers {
+		eachHandler(event.Connection)
+	}
+}
+
+// get the node endpoint currently used by consumer connection, password is redacted.
+// empty if not connected
+func (c *AMQPClient) CurrentEndpoint() string {
+	return redactEndpoint(c.consumeConn.currentEndpoint())
+}
+
+// get the node endpoint currently used by publisher connection, password is redacted.
+// empty if not connected
+func (c *AMQPClient) CurrentPublishEndpoint() string {
+	return redactEndpoint(c.publishConn.currentEndpoint())
+}
+
+// dial the cluster nodes one by one until success, they are tried from the first one,
+// and the lost endpoint is tried at last, so that a lost node is skipped when reconnecting
+//
+// connection parameter is the name of connection, such as consumer or publisher.
+// lost is the endpoint of the connection lost unexpectedly, empty if none
+func (c *AMQPClient) dial(connection string, lost string) (*amqp.Connection, string, error) {
+	credentials, err := c.credentials()
 	if err != nil {
-		return err
+		return nil, "", fmt.Errorf("cannot get credentials, %w", err)
 	}
-	c.conn = nil
-	c.channel = nil
-	c.isConnected = false
-	return nil
+	config := c.dialConfig(connection, credentials)
+	var errs []error
+	for _, eachEndpoint := range c.dialOrder(lost) {
+		conn, err := amqp.DialConfig(eachEndpoint, config)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("dial %s error: %w", redactEndpoint(eachEndpoint), err))
+			continue
+		}
+		go c.rotateSecret(conn, credentials)
+		return conn, eachEndpoint, nil
+	}
+	return nil, "", errors.Join(errs...)
 }
 
-func (c *AMQPClient) IsConnected() bool {
-	return c.isConnected
+// build amqp.Config used by dial, credentials replace the username and password in url when not nil
+func (c *AMQPClient) dialConfig(connection string, credentials *Credentials) amqp.Config {
+	// connection name is suffixed only when separate connections used, so they can be distinguished
+	if !c.separateConnections {
+		connection = ""
+	}
+	config := c.connectionOptions.buildConfig(connection)
+	if c.tlsConfig != nil {
+		// each dial uses its own copy, amqp may fill ServerName from url
+		config.TLSClientConfig = c.tlsConfig.Clone()
+		if c.tlsOptions.ExternalAuth {
+			config.SASL = []amqp.Authentication{&amqp.ExternalAuth{}}
+		}
+	}
+	if credentials != nil && config.SASL == nil {
+		config.SASL = []amqp.Authentication{&amqp.PlainAuth{
+			Username: credentials.Username,
+			Password: credentials.Password,
+		}}
+	}
+	return config
+}
+
+// endpoints in dial order, the lost endpoint is moved to the end unless shuffled
+func (c *AMQPClient) dialOrder(lost string) []string {
+	order := make([]string, 0, len(c.endpoints))
+	if c.shuffleEndpoints {
+		for _, eachIndex := range rand.Perm(len(c.endpoints)) {
+			order = append(order, c.endpoints[eachIndex])
+		}
+		return order
+	}
+	lostCount := 0
+	for _, eachEndpoint := range c.endpoints {
+		if eachEndpoint == lost {
+			lostCount++
+			continue
+		}
+		order = append(order, eachEndpoint)
+	}
+	for i := 0; i < lostCount; i++ {
+		order = append(order, lost)
+	}
+	return order
 }
 
-func (c *AMQPClient) ensureConnect() error {
-	return c.Connect()
+func splitEndpoints(rawUrl string) []string {
+	endpoints := make([]string, 0)
+	for _, eachUrl := range strings.Split(rawUrl, ",") {
+		eachUrl = strings.TrimSpace(eachUrl)
+		if eachUrl == "" {
+			continue
+		}
+		endpoints = append(endpoints, eachUrl)
+	}
+	return endpoints
 }
 
-// declare exchange
-func (c *AMQPClient) ExchangeDeclare(declare ExchangeDeclare) error {
-	err := c.ensureConnect()
+// hide password in endpoint url
+func redactEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
 	if err != nil {
-		return err
+		return endpoint
 	}
+	return u.Redacted()
+}
 
-	return c.channel.ExchangeDeclare(declare.Name,
-		string(declare.Kind),
-		declare.Durable,
-		declare.AutoDelete,
-		declare.Internal,
-		declare.NoWait,
-		declare.Args)
+func (c *AMQPClient) trackChannel(ch *amqp.Channel) {
+	c.channelsLock.Lock()
+	c.channels[ch] = struct{}{}
+	c.channelsLock.Unlock()
+
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		closeErr := <-closed
+		c.channelsLock.Lock()
+		delete(c.channels, ch)
+		c.channelsLock.Unlock()
+		c.channelLocks.Delete(ch)
+		c.publishEvent(Event_ChannelClosed, &ChannelClosedEvent{
+			Channel: ch,
+			Err:     amqpError(closeErr),
+		})
+	}()
+	cancelled := ch.NotifyCancel(make(chan string, 1))
+	go func() {
+		for eachConsumer := range cancelled {
+			c.publishEvent(Event_ConsumerCancelled, &ConsumerCancelledEvent{
+				Consumer: eachConsumer,
+			})
+		}
+	}()
+}
+
+// run fn with the specified channel, if channel is nil or empty, then the default channel of conn is used.
+// access to each channel is serialized, because amqp channel is not safe for concurrent publishing
+func (c *AMQPClient) doWithChannel(conn *amqpConnection, channel []WithChannel, fn func(ch *amqp.Channel) error) error {
+	var usedChannel *amqp.Channel
+	if len(channel) > 0 && channel[0].Channel != nil {
+		// the channel may belong to either connection, so do not connect conn
+		usedChannel = channel[0].Channel
+	} else {
+		err := conn.connect()
+		if err != nil {
+			return err
+		}
+		usedChannel = conn.defaultChannel()
+	}
+	if usedChannel == nil {
+		return amqp.ErrClosed
+	}
+	locker := c.channelLock(usedChannel)
+	locker.Lock()
+	defer locker.Unlock()
+	return fn(usedChannel)
+}
+
+func (c *AMQPClient) getChannelPool(confirm bool) *channelPool {
+	c.poolLock.Lock()
+	defer c.poolLock.Unlock()
+	if confirm {
+		if c.confirmPool == nil {
+			c.confirmPool = newChannelPool(c, c.channelPoolSize, true)
+		}
+		return c.confirmPool
+	}
+	if c.publishPool == nil {
+		c.publishPool = newChannelPool(c, c.channelPoolSize, false)
+	}
+	return c.publishPool
+}
+
+func (c *AMQPClient) channelLock(ch *amqp.Channel) *sync.Mutex {
+	locker, _ := c.channelLocks.LoadOrStore(ch, &sync.Mutex{})
+	return locker.(*sync.Mutex)
+}
+
+// remove all tracked channels and return them
+func (c *AMQPClient) takeChannels() []*amqp.Channel {
+	c.channelsLock.Lock()
+	defer c.channelsLock.Unlock()
+	channels := make([]*amqp.Channel, 0, len(c.channels))
+	for eachChannel := range c.channels {
+		channels = append(channels, eachChannel)
+	}
+	c.channels = make(map[*amqp.Channel]struct{})
+	return channels
+}
+
+// declare exchange
+func (c *AMQPClient) ExchangeDeclare(declare ExchangeDeclare) error {
+	return c.doWithChannel(c.consumeConn, nil, func(ch *amqp.Channel) error {
+		return ch.ExchangeDeclare(declare.Name,
+			string(declare.Kind),
+			declare.Durable,
+			declare.AutoDelete,
+			declare.Internal,
+			declare.NoWait,
+			declare.Args)
+	})
 }
 
 // declare queue
 func (c *AMQPClient) QueueDeclare(declare QueueDeclare) (*amqp.Queue, error) {
-	err := c.ensureConnect()
+	var q amqp.Queue
+	err := c.doWithChannel(c.consumeConn, nil, func(ch *amqp.Channel) (err error) {
+		q, err = ch.QueueDeclare(declare.Name,
+			declare.Durable,
+			declare.AutoDelete,
+			declare.Exclusive,
+			declare.NoWait,
+			declare.Args)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	q, err := c.channel.QueueDeclare(declare.Name,
-		declare.Durable,
-		declare.AutoDelete,
-		declare.Exclusive,
-		declare.NoWait,
-		declare.Args)
+	return &q, nil
+}
+
+// check whether the queue exists by passive declare, ErrQueueNotFound is returned if not exists.
+//
+// the broker closes the channel when the queue does not exist, so a temporary channel is used
+func (c *AMQPClient) QueueDeclarePassive(name string) (*amqp.Queue, error) {
+	ch, err := c.GetNewChannel()
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if !ch.IsClosed() {
+			ch.Close()
+		}
+	}()
+	q, err := ch.QueueDeclarePassive(name, false, false, false, false, nil)
+	if err != nil {
+		var amqpErr *amqp.Error
+		if errors.As(err, &amqpErr) && amqpErr.Code == amqp.NotFound {
+			return nil, fmt.Errorf("%w, queue: %s", ErrQueueNotFound, name)
+		}
+		return nil, err
+	}
 	return &q, nil
 }
 
 // bind exchange to a queue
 func (c *AMQPClient) QueueBind(bind QueueBind) error {
-	err := c.ensureConnect()
-	if err != nil {
-		return err
-	}
-	err = c.channel.QueueBind(bind.Queue, bind.RoutingKey, bind.Exchange, bind.NoWait, bind.Arguments)
-	if err != nil {
-		return err
-	}
-	return nil
+	return c.doWithChannel(c.consumeConn, nil, func(ch *amqp.Channel) error {
+		return ch.QueueBind(bind.Queue, bind.RoutingKey, bind.Exchange, bind.NoWait, bind.Arguments)
+	})
 }
 
 func (c *AMQPClient) Qos(prefetchCount, prefetchSize int, global bool, channel ...WithChannel) error {
-	err := c.ensureConnect()
-	if err != nil {
-		return err
-	}
-	usedChannel := c.channel
-	if len(channel) > 0 {
-		usedChannel = channel[0].Channel
-	}
-	return usedChannel.Qos(prefetchCount, prefetchSize, global)
+	return c.doWithChannel(c.consumeConn, channel, func(ch *amqp.Channel) error {
+		return ch.Qos(prefetchCount, prefetchSize, global)
+	})
 }
 
-// publish data to exchange
+// publish data to exchange, the default channel of the publishing connection is used if channel is not specified
 func (c *AMQPClient) PublishWithContext(ctx context.Context,
 	exchange string,
 	key string,
@@ -179,26 +518,101 @@ func (c *AMQPClient) PublishWithContext(ctx context.Context,
 	if key == "" {
 		return fmt.Errorf("key is empty")
 	}
-	err := c.ensureConnect()
+	err := c.doWithChannel(c.publishConn, channel, func(ch *amqp.Channel) error {
+		return ch.PublishWithContext(ctx,
+			exchange,  // exchange
+			key,       // routing key
+			mandatory, // mandatory
+			immediate, // immediate
+			amqp.Publishing{
+				ContentType: "text/plan",
+				Body:        data,
+			})
+	})
 	if err != nil {
 		return err
 	}
-	usedChannel := c.channel
-	if len(channel) > 0 {
-		usedChannel = channel[0].Channel
-	}
-	err = usedChannel.PublishWithContext(ctx,
-		exchange,  // exchange
-		key,       // routing key
-		mandatory, // mandatory
-		immediate, // immediate
-		amqp.Publishing{
-			ContentType: "text/plan",
-			Body:        data,
-		})
+	c.lastPublishAt.Store(time.Now().UnixNano())
+	return nil
+}
+
+// publish msg through a channel borrowed from the channel pool, so concurrent publishers scale across channels.
+//
+// when confirm is true, a confirm mode channel is used and it waits until the broker confirms the message,
+// ErrNacked is returned if the broker nacks it
+func (c *AMQPClient) PublishWithPool(ctx context.Context,
+	exchange string,
+	key string,
+	mandatory bool,
+	immediate bool,
+	confirm bool,
+	msg amqp.Publishing) error {
+	_, err := c.PublishWithPoolResult(ctx, exchange, key, mandatory, immediate, confirm, msg)
 	return err
 }
 
+// same as PublishWithPool, and returns the result, such as delivery tag and confirm latency.
+//
+// whether the message is returned can only be detected when both confirm and mandatory are true,
+// because the broker sends basic.return before basic.ack
+func (c *AMQPClient) PublishWithPoolResult(ctx context.Context,
+	exchange string,
+	key string,
+	mandatory bool,
+	immediate bool,
+	confirm bool,
+	msg amqp.Publishing) (*PublishResult, error) {
+	if len(msg.Body) == 0 {
+		return nil, fmt.Errorf("argument msg.Body is empty")
+	}
+	if key == "" {
+		return nil, fmt.Errorf("key is empty")
+	}
+	err := c.publishConn.connect()
+	if err != nil {
+		return nil, fmt.Errorf("%w, %w", ErrNotConnected, err)
+	}
+	pool := c.getChannelPool(confirm)
+	ch, err := pool.borrow(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &PublishResult{
+		MessageId: msg.MessageId,
+	}
+	if !confirm {
+		err = ch.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+		pool.giveBack(ch, isChannelBroken(err))
+		if err != nil {
+			return nil, err
+		}
+		c.lastPublishAt.Store(time.Now().UnixNano())
+		return result, nil
+	}
+	startAt := time.Now()
+	deferredConfirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	if err != nil {
+		pool.giveBack(ch, isChannelBroken(err))
+		return nil, err
+	}
+	result.DeliveryTag = deferredConfirm.DeliveryTag
+	confirmation, err := pool.tracker(ch).wait(ctx, deferredConfirm.DeliveryTag)
+	// a channel that has an outstanding confirm cannot be reused safely
+	pool.giveBack(ch, err != nil)
+	if err != nil {
+		return nil, err
+	}
+	result.ConfirmLatency = time.Since(startAt)
+	if !confirmation.ack {
+		return result, ErrNacked
+	}
+	result.Confirmed = true
+	result.Return = confirmation.ret
+	result.Returned = confirmation.ret != nil
+	c.lastPublishAt.Store(time.Now().UnixNano())
+	return result, nil
+}
+
 // consume queue
 //
 // channel parameter indicate used specified channel,if nil or empty,then used default channel
@@ -206,19 +620,38 @@ func (c *AMQPClient) Consume(consume *QueueConsume, channel ...WithChannel) (<-c
 	if consume.Queue == "" {
 		return nil, fmt.Errorf("consum.Queue field value cannot be empty")
 	}
-	err := c.ensureConnect()
+	var deliveries <-chan amqp.Delivery
+	err := c.doWithChannel(c.consumeConn, channel, func(ch *amqp.Channel) (err error) {
+		deliveries, err = ch.Consume(consume.Queue,
+			consume.Consumer,
+			consume.AutoAck,
+			consume.Exclusive,
+			false,
+			consume.NoWait,
+			consume.Args)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	usedChannel := c.channel
-	if len(channel) > 0 {
-		usedChannel = channel[0].Channel
+	return deliveries, nil
+}
+
+// get a single message from queue by basic.get
+//
+// ok is false when the queue is empty.
+// channel parameter indicate used specified channel,if nil or empty,then used default channel
+func (c *AMQPClient) Get(queue string, autoAck bool, channel ...WithChannel) (delivery *amqp.Delivery, ok bool, err error) {
+	if queue == "" {
+		return nil, false, fmt.Errorf("queue cannot be empty")
+	}
+	var msg amqp.Delivery
+	err = c.doWithChannel(c.consumeConn, channel, func(ch *amqp.Channel) (err error) {
+		msg, ok, err = ch.Get(queue, autoAck)
+		return err
+	})
+	if err != nil || !ok {
+		return nil, ok, err
 	}
-	return usedChannel.Consume(consume.Queue,
-		consume.Consumer,
-		consume.AutoAck,
-		consume.Exclusive,
-		false,
-		consume.NoWait,
-		consume.Args)
+	return &msg, true, nil
 }
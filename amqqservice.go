@@ -1,17 +1,23 @@
 package amqpx
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 var consumerSeq uint64
 
+// ErrShutdown returned when publish or consume on a shutdown service
+var ErrShutdown = errors.New("amqp service is shutdown")
+
 const consumerTagLengthMax = 0xFF // see writeShortstr
 
 type IAMQPService interface {
@@ -21,16 +27,49 @@ type IAMQPService interface {
 	ExchangeDeclare(declare ExchangeDeclare) error
 	QueueDeclare(declare QueueDeclare) error
 	QueueBind(bind QueueBind) error
+
+	// Shutdown service gracefully.
+	//
+	// it cancels all consumers, waits for in-progress handlers to finish and ack,
+	// waits for in-flight publishing to complete, then closes all channels and the connection.
+	// when ctx is done before draining completed, channels and connection are closed immediately
+	// and ctx.Err() is returned
+	Shutdown(ctx context.Context) error
+
+	// report the health status of connections and consumers
+	Health(ctx context.Context) *HealthStatus
 }
 
 type IAMQPPublisher interface {
 	Publish(v interface{}, opts ...PublishOption) error
+
+	// same as Publish, and returns the result, such as message id, delivery tag and confirm latency.
+	// use it with WithConfirm and WithMandatory to learn whether the message is confirmed or returned
+	PublishWithResult(v interface{}, opts ...PublishOption) (*PublishResult, error)
+
+	// publish to queue directly through the default exchange, the queue name is used as routing key.
+	// with WithVerifyQueue, ErrQueueNotFound is returned if the queue does not exist
+	SendToQueue(ctx context.Context, queue string, v interface{}, opts ...PublishOption) error
 }
 
 type IAMQPConsumer interface {
 	Qos(prefetchCount, prefetchSize int, global bool, channel ...WithChannel) error
 
-	SimpleConsume(topic string, consumer string, observeFn func(msg *DeliveryMessage)) (ITopicConsumer, error)
+	SimpleConsume(topic string, consumer string, observeFn func(msg *DeliveryMessage), opts ...ConsumeOption) (ITopicConsumer, error)
+
+	// subscribe messages published to exchange with routing key matching routingPattern.
+	// the exchange, the queue and the binding are declared if needed, each subscriber gets its own
+	// queue so every subscriber receives a copy of the message, see WithSubscribeQueue
+	Subscribe(exchange string, routingPattern string, observeFn func(msg *DeliveryMessage), opts ...SubscribeOption) (ITopicConsumer, error)
+
+	// consume topic in batches, handler will be invoked when batchSize messages have been collected
+	// or maxLatency elapsed since the first message of the batch arrived.
+	// it resubscribes on a new channel after the channel or connection is lost
+	BatchConsume(topic string, consumer string, batchSize int, maxLatency time.Duration, handler BatchHandler, opts ...BatchConsumeOption) (IBatchConsumer, error)
+
+	// pull at most max messages from topic by basic.get, returns when topic is empty or ctx is done.
+	// the messages are not auto acked, you must ack them like consumed messages
+	Pull(ctx context.Context, topic string, max int) ([]*DeliveryMessage, error)
 
 	// general unique consumer name, this cannot guarante value is unique in distributed environment
 	GenerateUniqueConsumerName() string
@@ -40,18 +79,53 @@ type amqpService struct {
 	client *AMQPClient
 	defaultTopicConsumer
 
-	publishChannel *amqp.Channel
-	// consuming channel, key is topic
+	// consuming channel, key is consumer tag, each consumer has its own channel
 	consumedChannel map[string]*amqp.Channel
-	lock            sync.Mutex
+	// pulling channel used by Pull, key is topic
+	pulledChannel map[string]*amqp.Channel
+	// all consumers created by service, they will be drained when shutdown
+	consumers  map[managedConsumer]struct{}
+	publishing sync.WaitGroup
+	isShutdown bool
+	lock       sync.Mutex
+
+	blockedPolicy BlockedPolicy
+	// stamp published messages
+	messageIdGenerator func() string
+	appId              string
+
+	// hold messages while publishing connection is lost, nil if not enabled
+	publishBufferOptions *PublishBufferOptions
+	publishBuffer        *publishBuffer
+	// the error creating publish buffer, it is returned by publishing
+	publishBufferErr error
+
+	// unix nano of the last message pulled
+	lastConsumeAt atomic.Int64
 }
 
 // create IAMQPService instance
-func NewAMQPService(client *AMQPClient) IAMQPService {
-	return &amqpService{
+func NewAMQPService(client *AMQPClient, opts ...ServiceOption) IAMQPService {
+	s := &amqpService{
 		client:          client,
 		consumedChannel: make(map[string]*amqp.Channel),
+		pulledChannel:   make(map[string]*amqp.Channel),
+		consumers:       make(map[managedConsumer]struct{}),
+		blockedPolicy:   BlockedPolicy_Wait,
+
+		messageIdGenerator: NewUUIDv7,
+		appId:              filepath.Base(appName()),
+	}
+	for _, eachOpt := range opts {
+		eachOpt(s)
+	}
+	if s.publishBufferOptions != nil {
+		s.publishBuffer, s.publishBufferErr = newPublishBuffer(client, s.publishBufferOptions)
 	}
+	client.OnConnected(func(connection string) {
+		s.notifyReconnected()
+	})
+	return s
 }
 
 // #region IAMQPService members
@@ -80,15 +154,70 @@ func (s *amqpService) QueueBind(bind QueueBind) error {
 	return nil
 }
 
+func (s *amqpService) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	if s.isShutdown {
+		s.lock.Unlock()
+		return nil
+	}
+	s.isShutdown = true
+	consumers := make([]managedConsumer, 0, len(s.consumers))
+	for eachConsumer := range s.consumers {
+		consumers = append(consumers, eachConsumer)
+	}
+	s.lock.Unlock()
+
+	var errs []error
+	// stop receiving new deliveries, the deliveries in flight are still delivered to handlers
+	for _, eachConsumer := range consumers {
+		err := eachConsumer.cancel()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err := s.waitDrained(ctx, consumers)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if s.publishBuffer != nil {
+		// replay buffered messages before closing connection
+		if err == nil {
+			err = s.publishBuffer.waitFlushed(ctx)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		err = s.publishBuffer.close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err = s.closeChannels()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	err = s.client.Close()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
 // #endregion
 
 // #region IAMQPPublisher Members
 
 func (s *amqpService) Publish(v interface{}, opts ...PublishOption) error {
-	err := s.ensurePublishChannelInit()
-	if err != nil {
-		return err
+	_, err := s.PublishWithResult(v, opts...)
+	return err
+}
+
+func (s *amqpService) PublishWithResult(v interface{}, opts ...PublishOption) (*PublishResult, error) {
+	if !s.beginPublish() {
+		return nil, ErrShutdown
 	}
+	defer s.publishing.Done()
+
 	publishContext := NewDefaultPublishContext()
 
 	for _, eachOpt := range opts {
@@ -97,20 +226,88 @@ func (s *amqpService) Publish(v interface{}, opts ...PublishOption) error {
 	if publishContext.cancelFunc != nil {
 		defer publishContext.cancelFunc()
 	}
+	publishContext.stamp(s.messageIdGenerator, s.appId)
+	if publishContext.result != nil {
+		publishContext.result.MessageId = publishContext.messageId
+	}
 
 	data, err := publishContext.Marshal(v)
 	if err != nil {
-		return fmt.Errorf("cannot serialize object,v: %+V", v)
+		return nil, fmt.Errorf("cannot serialize object,v: %+V", v)
+	}
+
+	if s.publishBufferErr != nil {
+		return nil, s.publishBufferErr
+	}
+	msg := &bufferedPublishing{
+		Exchange:  publishContext.exchange,
+		Key:       publishContext.key,
+		Mandatory: publishContext.mandatory,
+		Immediate: publishContext.immediate,
+		Confirm:   publishContext.confirm,
+		Publishing: amqp.Publishing{
+			ContentType:  "text/plan",
+			DeliveryMode: publishContext.deliveryMode,
+			MessageId:    publishContext.messageId,
+			Timestamp:    publishContext.timestamp,
+			AppId:        publishContext.appId,
+			Body:         data,
+		},
+	}
+	// keep order, the messages are buffered until the buffered ones are replayed
+	if s.publishBuffer != nil && (s.publishBuffer.hasPending() || s.client.publishConn.isRecovering()) {
+		if !msg.Confirm {
+			return s.bufferPublishing(publishContext, msg)
+		}
+		// a confirmed publishing is not buffered, it succeeds only when the broker confirms it
+		if !s.client.publishConn.connected() {
+			return nil, fmt.Errorf("%w, the publishing connection is recovering", ErrNotConnected)
+		}
+		err = s.publishBuffer.waitFlushed(publishContext.ctx)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return s.client.PublishWithContext(publishContext.ctx,
-		publishContext.exchange,
-		publishContext.key,
-		publishContext.mandatory,
-		publishContext.immediate,
-		data,
-		WithChannel{Channel: s.publishChannel},
+	err = s.waitUnblocked(publishContext.ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.client.PublishWithPoolResult(publishContext.ctx,
+		msg.Exchange,
+		msg.Key,
+		msg.Mandatory,
+		msg.Immediate,
+		msg.Confirm,
+		msg.Publishing,
 	)
+	if err != nil && s.publishBuffer != nil && !msg.Confirm && s.publishBuffer.isConnectionLost(err) {
+		return s.bufferPublishing(publishContext, msg)
+	}
+	if result != nil && publishContext.result != nil {
+		*publishContext.result = *result
+	}
+	return result, err
+}
+
+func (s *amqpService) SendToQueue(ctx context.Context, queue string, v interface{}, opts ...PublishOption) error {
+	if queue == "" {
+		return fmt.Errorf("queue can not be empty")
+	}
+	publishOpts := append([]PublishOption{WithContext(ctx, nil)}, opts...)
+	publishOpts = append(publishOpts, WithExchange(""), WithKey(queue))
+	// only used to read options, the context is created by Publish
+	publishContext := &PublishContext{}
+	for _, eachOpt := range publishOpts {
+		eachOpt(publishContext)
+	}
+	if publishContext.verifyQueue {
+		_, err := s.client.QueueDeclarePassive(queue)
+		if err != nil {
+			return err
+		}
+	}
+	return s.Publish(v, publishOpts...)
 }
 
 // #endregion
@@ -154,28 +351,190 @@ func (s *amqpService) Qos(prefetchCount, prefetchSize int, global bool, channel
 	return s.client.Qos(prefetchCount, prefetchSize, global, channel...)
 }
 
-func (s *amqpService) SimpleConsume(topic string, consumer string, observeFn func(msg *DeliveryMessage)) (ITopicConsumer, error) {
+func (s *amqpService) SimpleConsume(topic string,
+	consumer string,
+	observeFn func(msg *DeliveryMessage),
+	opts ...ConsumeOption) (ITopicConsumer, error) {
 	if topic == "" {
 		return nil, fmt.Errorf("topic can not be empty")
 	}
 	if consumer == "" {
 		consumer = s.GenerateUniqueConsumerName()
 	}
-	channel, err := s.getOrCreateChannel(topic)
+	options := newDefaultConsumeOptions()
+	for _, eachOpt := range opts {
+		eachOpt(options)
+	}
+	declare := func() (string, error) {
+		if options.Redeclare != nil {
+			err := s.QueueDeclare(*options.Redeclare)
+			if err != nil {
+				return "", err
+			}
+		}
+		return topic, nil
+	}
+	return s.startConsumer(consumer, observeFn, options, declare)
+}
+
+func (s *amqpService) Subscribe(exchange string,
+	routingPattern string,
+	observeFn func(msg *DeliveryMessage),
+	opts ...SubscribeOption) (ITopicConsumer, error) {
+	if exchange == "" {
+		return nil, fmt.Errorf("exchange can not be empty")
+	}
+	options := newDefaultSubscribeOptions()
+	for _, eachOpt := range opts {
+		eachOpt(options)
+	}
+	consumer := options.Consumer
+	if consumer == "" {
+		consumer = s.GenerateUniqueConsumerName()
+	}
+	consumeOptions := newDefaultConsumeOptions()
+	for _, eachOpt := range options.ConsumeOptions {
+		eachOpt(consumeOptions)
+	}
+	// declare exchange, queue and binding on each subscribe,
+	// because the server-named queue is deleted with the connection
+	declare := func() (string, error) {
+		err := s.ExchangeDeclare(ExchangeDeclare{
+			Name:    exchange,
+			Kind:    options.ExchangeKind,
+			Durable: options.ExchangeDurable,
+		})
+		if err != nil {
+			return "", err
+		}
+		queueDeclare := QueueDeclare{
+			Name:    options.Queue,
+			Durable: true,
+			Args:    options.QueueArgs,
+		}
+		if options.Queue == "" {
+			queueDeclare.Durable = false
+			queueDeclare.AutoDelete = true
+			queueDeclare.Exclusive = true
+		}
+		q, err := s.client.QueueDeclare(queueDeclare)
+		if err != nil {
+			return "", err
+		}
+		err = s.QueueBind(QueueBind{
+			Queue:      q.Name,
+			Exchange:   exchange,
+			RoutingKey: routingPattern,
+		})
+		if err != nil {
+			return "", err
+		}
+		return q.Name, nil
+	}
+	return s.startConsumer(consumer, observeFn, consumeOptions, declare)
+}
+
+func (s *amqpService) BatchConsume(topic string,
+	consumer string,
+	batchSize int,
+	maxLatency time.Duration,
+	handler BatchHandler,
+	opts ...BatchConsumeOption) (IBatchConsumer, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("topic can not be empty")
+	}
+	if handler == nil {
+		return nil, fmt.Errorf("handler can not be nil")
+	}
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batchSize must be greater than 0")
+	}
+	if maxLatency <= 0 {
+		return nil, fmt.Errorf("maxLatency must be greater than 0")
+	}
+	if s.shutdown() {
+		return nil, ErrShutdown
+	}
+	if consumer == "" {
+		consumer = s.GenerateUniqueConsumerName()
+	}
+	options := newDefaultBatchConsumeOptions()
+	for _, eachOpt := range opts {
+		eachOpt(options)
+	}
+	// batch is acked with multiple=true, which acks all prior deliveries on the channel,
+	// so the batch consumer must own its channel, a new one is opened on each subscribe
+	subscribe := func() (*amqp.Channel, <-chan amqp.Delivery, error) {
+		if s.shutdown() || s.client.consumeConn.closed() {
+			// closed by client, it should not be resubscribed
+			return nil, nil, ErrShutdown
+		}
+		channel, err := s.client.GetNewChannel()
+		if err != nil {
+			return nil, nil, err
+		}
+		// prefetch must be able to hold a whole batch, otherwise the batch can never be filled
+		err = s.client.Qos(batchSize, 0, false, WithChannel{channel})
+		if err != nil {
+			channel.Close()
+			return nil, nil, err
+		}
+		queueConsume := NewDefaultQueueConsume(topic)
+		queueConsume.Consumer = consumer
+		ch, err := s.client.Consume(queueConsume, WithChannel{channel})
+		if err != nil {
+			channel.Close()
+			return nil, nil, err
+		}
+		return channel, ch, nil
+	}
+	channel, ch, err := subscribe()
 	if err != nil {
 		return nil, err
 	}
-	queueConsume := NewDefaultQueueConsume(topic)
-	ch, err := s.client.Consume(queueConsume, WithChannel{channel})
+	batchConsumer := newDefaultBatchConsumer(consumer, topic, channel, ch, batchSize, maxLatency, handler, options, subscribe)
+	err = s.addConsumer(batchConsumer)
+	if err != nil {
+		return nil, err
+	}
+	return batchConsumer, nil
+}
+
+func (s *amqpService) Pull(ctx context.Context, topic string, max int) ([]*DeliveryMessage, error) {
+	if topic == "" {
+		return nil, fmt.Errorf("topic can not be empty")
+	}
+	if max <= 0 {
+		return nil, fmt.Errorf("max must be greater than 0")
+	}
+	if s.shutdown() {
+		return nil, ErrShutdown
+	}
+	channel, err := s.getOrCreateChannel(s.pulledChannel, topic, 0)
 	if err != nil {
 		return nil, err
 	}
-	return newDefaultConsumer(consumer, channel, ch, observeFn), nil
+	messages := make([]*DeliveryMessage, 0, max)
+	for len(messages) < max {
+		if ctx.Err() != nil {
+			break
+		}
+		delivery, ok, err := s.client.Get(topic, false, WithChannel{channel})
+		if err != nil {
+			return messages, err
+		}
+		if !ok {
+			break
+		}
+		s.lastConsumeAt.Store(time.Now().UnixNano())
+		messages = append(messages, newDeliveryMessage(delivery))
+	}
+	return messages, nil
 }
 
 func (s *amqpService) GenerateUniqueConsumerName() string {
 	tagPrefix := "ctag-"
-	tagInfix := os.Args[0]
+	tagInfix := appName()
 	tagSuffix := "-" + strconv.FormatUint(atomic.AddUint64(&consumerSeq, 1), 10)
 	if len(tagPrefix)+len(tagInfix)+len(tagSuffix) > consumerTagLengthMax {
 		tagInfix = "abmpio/amqpx"
@@ -185,27 +544,216 @@ func (s *amqpService) GenerateUniqueConsumerName() string {
 
 // #endregion
 
-func (s *amqpService) ensurePublishChannelInit() error {
-	if s.publishChannel != nil {
+// start a consumer on its own channel, declare is invoked before each subscribe and returns the queue to consume
+func (s *amqpService) startConsumer(consumer string,
+	observeFn func(msg *DeliveryMessage),
+	options *ConsumeOptions,
+	declare func() (string, error)) (ITopicConsumer, error) {
+	if s.hasConsumedChannel(consumer) {
+		return nil, fmt.Errorf("consumer %s already exists", consumer)
+	}
+	// the queue of the first subscribe is reported as the topic of consumer
+	var topic string
+	subscribe := func() (*amqp.Channel, <-chan amqp.Delivery, error) {
+		if s.shutdown() || s.client.consumeConn.closed() {
+			// closed by client, it should not be resubscribed
+			return nil, nil, ErrShutdown
+		}
+		queue, err := declare()
+		if err != nil {
+			return nil, nil, err
+		}
+		if topic == "" {
+			topic = queue
+		}
+		channel, err := s.getOrCreateChannel(s.consumedChannel, consumer, options.PrefetchCount)
+		if err != nil {
+			return nil, nil, err
+		}
+		queueConsume := NewDefaultQueueConsume(queue)
+		queueConsume.Consumer = consumer
+		ch, err := s.client.Consume(queueConsume, WithChannel{channel})
+		if err != nil {
+			return nil, nil, err
+		}
+		return channel, ch, nil
+	}
+	channel, ch, err := subscribe()
+	if err != nil {
+		return nil, err
+	}
+	var topicConsumer *defaultTopicConsumer
+	release := func(channel *amqp.Channel) {
+		s.removeConsumer(topicConsumer)
+		s.releaseChannel(consumer, channel)
+	}
+	topicConsumer = newDefaultConsumer(consumer, topic, channel, ch, observeFn, options, subscribe, release)
+	err = s.addConsumer(topicConsumer)
+	if err != nil {
+		return nil, err
+	}
+	return topicConsumer, nil
+}
+
+// apply blocked policy before publishing
+func (s *amqpService) waitUnblocked(ctx context.Context) error {
+	if !s.client.IsBlocked() {
 		return nil
 	}
-	s.client.ensureConnect()
-	var err error
-	s.publishChannel, err = s.client.GetNewChannel()
-	return err
+	if s.blockedPolicy == BlockedPolicy_FailFast {
+		return ErrBlocked
+	}
+	return s.client.WaitUnblocked(ctx)
+}
+
+func (s *amqpService) shutdown() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.isShutdown
+}
+
+func (s *amqpService) beginPublish() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.isShutdown {
+		return false
+	}
+	s.publishing.Add(1)
+	return true
 }
 
-func (s *amqpService) getOrCreateChannel(topic string) (*amqp.Channel, error) {
+// register consumer, so that it can be drained when shutdown.
+// if service is already shutdown, the consumer will be canceled
+func (s *amqpService) addConsumer(consumer managedConsumer) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	var err error
-	c, ok := s.consumedChannel[topic]
-	if !ok {
-		c, err = s.client.GetNewChannel()
+	if s.isShutdown {
+		consumer.cancel()
+		return ErrShutdown
+	}
+	s.consumers[consumer] = struct{}{}
+	return nil
+}
+
+// wake the consumers waiting for resubscribe after the connection recovered
+func (s *amqpService) notifyReconnected() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for eachConsumer := range s.consumers {
+		if c, ok := eachConsumer.(interface{ reconnected() }); ok {
+			c.reconnected()
+		}
+	}
+}
+
+func (s *amqpService) removeConsumer(consumer managedConsumer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.consumers, consumer)
+}
+
+// close the consuming channel of consumer, so that the consumer can be created cleanly again
+func (s *amqpService) releaseChannel(consumer string, channel *amqp.Channel) {
+	s.lock.Lock()
+	if s.consumedChannel[consumer] == channel {
+		delete(s.consumedChannel, consumer)
+	}
+	s.lock.Unlock()
+	if !channel.IsClosed() {
+		channel.Close()
+	}
+}
+
+// hold msg in publish buffer, it is published after reconnected
+func (s *amqpService) bufferPublishing(publishContext *PublishContext, msg *bufferedPublishing) (*PublishResult, error) {
+	if len(msg.Publishing.Body) == 0 {
+		return nil, fmt.Errorf("argument msg.Body is empty")
+	}
+	if msg.Key == "" {
+		return nil, fmt.Errorf("key is empty")
+	}
+	err := s.publishBuffer.add(publishContext.ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	result := &PublishResult{
+		MessageId: msg.Publishing.MessageId,
+		Buffered:  true,
+	}
+	if publishContext.result != nil {
+		*publishContext.result = *result
+	}
+	return result, nil
+}
+
+// wait all consumers exited and all in-flight publishing completed
+func (s *amqpService) waitDrained(ctx context.Context, consumers []managedConsumer) error {
+	for _, eachConsumer := range consumers {
+		select {
+		case <-eachConsumer.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	published := make(chan struct{})
+	go func() {
+		s.publishing.Wait()
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (s *amqpService) closeChannels() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var errs []error
+	for _, eachChannels := range []map[string]*amqp.Channel{s.consumedChannel, s.pulledChannel} {
+		for eachKey, eachChannel := range eachChannels {
+			delete(eachChannels, eachKey)
+			if eachChannel.IsClosed() {
+				continue
+			}
+			err := eachChannel.Close()
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *amqpService) hasConsumedChannel(consumer string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.consumedChannel[consumer]
+	return ok
+}
+
+// get the channel of key from channels, a new channel is created when not found or closed.
+// when prefetchCount is greater than 0, it is applied to the new channel
+func (s *amqpService) getOrCreateChannel(channels map[string]*amqp.Channel, key string, prefetchCount int) (*amqp.Channel, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, ok := channels[key]
+	if ok && !c.IsClosed() {
+		return c, nil
+	}
+	c, err := s.client.GetNewChannel()
+	if err != nil {
+		return nil, err
+	}
+	if prefetchCount > 0 {
+		err = s.client.Qos(prefetchCount, 0, false, WithChannel{c})
 		if err != nil {
+			c.Close()
 			return nil, err
 		}
-		s.consumedChannel[topic] = c
 	}
+	channels[key] = c
 	return c, nil
 }
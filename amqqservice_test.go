@@ -0,0 +1,163 @@
+package amqpx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAMQPService_ConcurrentPublishConsumeDeclare(t *testing.T) {
+	broker := newFakeBroker(t)
+	client := newTestClient(t, broker, WithSeparateConnections(true))
+	err := client.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewAMQPService(client)
+	err = service.QueueDeclare(QueueDeclare{Name: "orders", Durable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var consumed, subscribed atomic.Int64
+	_, err = service.SimpleConsume("orders", "", func(msg *DeliveryMessage) {
+		consumed.Add(1)
+		msg.Ack(false)
+	}, WithConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.Subscribe("events", "order.#", func(msg *DeliveryMessage) {
+		subscribed.Add(1)
+		msg.Ack(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const publishers, messages = 8, 25
+	wg := sync.WaitGroup{}
+	errs := make(chan error, publishers*2)
+	for i := 0; i < publishers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				err := service.Publish(map[string]int{"publisher": i, "message": j},
+					WithKey("orders"),
+					WithConfirm(j%2 == 0))
+				if err == nil {
+					err = service.Publish(j, WithExchange("events"), WithKey("order.created"))
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				name := fmt.Sprintf("declared-%d-%d", i, j%5)
+				err := service.ExchangeDeclare(ExchangeDeclare{Name: name, Kind: Exchange_Fanout})
+				if err == nil {
+					err = service.QueueDeclare(QueueDeclare{Name: name})
+				}
+				if err == nil {
+					err = service.QueueBind(QueueBind{Queue: name, Exchange: name})
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for eachErr := range errs {
+		t.Fatal(eachErr)
+	}
+
+	waitFor(t, 10*time.Second, func() bool {
+		return consumed.Load() == publishers*messages && subscribed.Load() == publishers*messages
+	})
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFunc()
+	err = service.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.Pull(context.Background(), "orders", 1)
+	if err != ErrShutdown {
+		t.Fatalf("expect ErrShutdown after Shutdown, got %v", err)
+	}
+}
+
+func TestAMQPService_Pull(t *testing.T) {
+	broker := newFakeBroker(t)
+	client := newTestClient(t, broker)
+	err := client.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewAMQPService(client)
+	err = service.QueueDeclare(QueueDeclare{Name: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		err = service.Publish(i, WithKey("orders"), WithConfirm(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+
+	// stop at max
+	messages, err := service.Pull(ctx, "orders", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expect 2 messages pulled, got %d", len(messages))
+	}
+	// stop when the queue is empty
+	remaining, err := service.Pull(ctx, "orders", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 {
+		t.Fatalf("expect 1 message pulled before the queue is empty, got %d", len(remaining))
+	}
+	messages = append(messages, remaining...)
+	for i, eachMessage := range messages {
+		var value int
+		err = eachMessage.ToValue(&value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != i {
+			t.Fatalf("expect message %d pulled in order, got %d", i, value)
+		}
+	}
+
+	// pulled messages are not acked until acked by caller
+	ready, unacked := broker.messages("orders")
+	if ready != 0 || unacked != 3 {
+		t.Fatalf("expect 0 ready and 3 unacked messages, got %d and %d", ready, unacked)
+	}
+	for _, eachMessage := range messages {
+		err = eachMessage.Ack(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		ready, unacked := broker.messages("orders")
+		return ready == 0 && unacked == 0
+	})
+}
@@ -0,0 +1,301 @@
+package amqpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// BatchHandler process a batch of delivery messages.
+//
+// When it returns nil the whole batch is acknowledged with Ack(multiple=true),
+// otherwise the whole batch is negatively acknowledged, and requeued unless WithBatchRequeueOnError(false).
+// ctx will be canceled after the consumer is stopped and the last batch is handled
+type BatchHandler func(ctx context.Context, msgs []*DeliveryMessage) error
+
+// IBatchConsumer define a amqp queue consumer that delivers messages in batches
+type IBatchConsumer interface {
+	// closed when the consume loop exited
+	Done() <-chan struct{}
+
+	// stop consume, the messages already collected will still be delivered to handler
+	Stop() error
+}
+
+type defaultBatchConsumer struct {
+	consumer string
+	topic    string
+	channel  *amqp.Channel
+	ch       <-chan amqp.Delivery
+	// protect channel and ch, they are replaced when resubscribe
+	lock sync.Mutex
+	// subscribe on a new owned channel, used to resubscribe after the channel or connection is lost
+	subscribe subscribeFunc
+
+	batchSize  int
+	maxLatency time.Duration
+	handler    BatchHandler
+	options    *BatchConsumeOptions
+
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+
+	unmarshal func([]byte, interface{}) error
+
+	// closed when consume loop exited
+	doneCh chan struct{}
+	// stopped by Stop
+	stopped atomic.Bool
+	// cancelled by Stop or service shutdown, so it should not be resubscribed
+	cancelling atomic.Bool
+	// closed when cancelled, so the consume loop waiting for resubscribe exits
+	cancelCh   chan struct{}
+	cancelOnce sync.Once
+	// signal the consume loop waiting for resubscribe that the connection is recovered
+	reconnectedCh chan struct{}
+	// unix nano of the last delivery
+	lastConsumeAt atomic.Int64
+}
+
+var _ IBatchConsumer = (*defaultBatchConsumer)(nil)
+var _ managedConsumer = (*defaultBatchConsumer)(nil)
+
+func newDefaultBatchConsumer(consumer string,
+	topic string,
+	channel *amqp.Channel,
+	ch <-chan amqp.Delivery,
+	batchSize int,
+	maxLatency time.Duration,
+	handler BatchHandler,
+	options *BatchConsumeOptions,
+	subscribe subscribeFunc) *defaultBatchConsumer {
+	if options == nil {
+		options = newDefaultBatchConsumeOptions()
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	batchConsumer := &defaultBatchConsumer{
+		consumer:      consumer,
+		topic:         topic,
+		channel:       channel,
+		ch:            ch,
+		subscribe:     subscribe,
+		batchSize:     batchSize,
+		maxLatency:    maxLatency,
+		handler:       handler,
+		options:       options,
+		ctx:           ctx,
+		cancelFunc:    cancelFunc,
+		unmarshal:     _unmarshal,
+		doneCh:        make(chan struct{}),
+		cancelCh:      make(chan struct{}),
+		reconnectedCh: make(chan struct{}, 1),
+	}
+	batchConsumer.start()
+	return batchConsumer
+}
+
+// #region IBatchConsumer Members
+
+func (c *defaultBatchConsumer) Done() <-chan struct{} {
+	return c.doneCh
+}
+
+func (c *defaultBatchConsumer) Stop() error {
+	c.stopped.Store(true)
+	// ctx is canceled after the consume loop exited, so the collected messages are handled with a live ctx
+	return c.cancel()
+}
+
+// #endregion
+
+func (c *defaultBatchConsumer) cancel() error {
+	c.cancelling.Store(true)
+	c.cancelOnce.Do(func() {
+		close(c.cancelCh)
+	})
+	channel, _ := c.current()
+	// wait for cancel-ok, so the deliveries in flight are still drained to the consume loop
+	err := channel.Cancel(c.consumer, false)
+	if err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func (c *defaultBatchConsumer) health() ConsumerHealth {
+	return newConsumerHealth(c.consumer, c.topic, c.Done(), c.stopped.Load(), c.lastConsumeAt.Load())
+}
+
+func (c *defaultBatchConsumer) start() {
+	go c.safeStart()
+}
+
+func (c *defaultBatchConsumer) safeStart() {
+	defer close(c.doneCh)
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Printf("defaultBatchConsumer.safeStart panic, panic: %v", p)
+			c.Stop()
+		}
+		c.cancelFunc()
+		// the channel is owned by this consumer
+		channel, _ := c.current()
+		channel.Close()
+	}()
+
+	for {
+		channel, ch := c.current()
+		c.consume(channel, ch)
+		if !c.isChannelLost(channel) {
+			return
+		}
+		// the channel or connection is lost, resubscribe once the connection recovered
+		if !c.resubscribe() {
+			return
+		}
+	}
+}
+
+// collect deliveries into batches until deliveries closed
+func (c *defaultBatchConsumer) consume(channel *amqp.Channel, ch <-chan amqp.Delivery) {
+	batch := make([]*DeliveryMessage, 0, c.batchSize)
+	timer := time.NewTimer(c.maxLatency)
+	stopTimer(timer)
+	for {
+		select {
+		case eachDelivery, ok := <-ch:
+			if !ok {
+				stopTimer(timer)
+				// the batch cannot be acked on a lost channel, it is redelivered after resubscribed
+				if !c.isChannelLost(channel) {
+					c.flush(batch)
+				}
+				return
+			}
+			c.lastConsumeAt.Store(time.Now().UnixNano())
+			if len(batch) == 0 {
+				timer.Reset(c.maxLatency)
+			}
+			newMessage := newDeliveryMessage(&eachDelivery)
+			newMessage.unmarshal = c.unmarshal
+			batch = append(batch, newMessage)
+			if len(batch) >= c.batchSize {
+				stopTimer(timer)
+				batch = c.flush(batch)
+			}
+		case <-timer.C:
+			batch = c.flush(batch)
+		}
+	}
+}
+
+func (c *defaultBatchConsumer) current() (*amqp.Channel, <-chan amqp.Delivery) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.channel, c.ch
+}
+
+// deliveries are closed because the channel is closed, but the consumer is not cancelled by client
+func (c *defaultBatchConsumer) isChannelLost(channel *amqp.Channel) bool {
+	return !c.cancelling.Load() && channel.IsClosed()
+}
+
+// signal the consume loop waiting for resubscribe that the connection is recovered
+func (c *defaultBatchConsumer) reconnected() {
+	select {
+	case c.reconnectedCh <- struct{}{}:
+	default:
+	}
+}
+
+// resubscribe on a new channel with backoff until success or cancelled, returns false when cancelled.
+// it retries immediately when the connection is recovered
+func (c *defaultBatchConsumer) resubscribe() bool {
+	if c.subscribe == nil {
+		return false
+	}
+	interval := _defaultResubscribeBackoff
+	for {
+		select {
+		case <-c.cancelCh:
+			return false
+		case <-c.reconnectedCh:
+		case <-time.After(interval):
+		}
+		channel, ch, err := c.subscribe()
+		if errors.Is(err, ErrShutdown) {
+			return false
+		}
+		if err != nil {
+			c.notifyError(fmt.Errorf("cannot resubscribe topic %s, %w", c.topic, err))
+			interval *= 2
+			if interval > _defaultMaxReconnectInterval {
+				interval = _defaultMaxReconnectInterval
+			}
+			continue
+		}
+		c.lock.Lock()
+		lost := c.channel
+		c.channel = channel
+		c.ch = ch
+		c.lock.Unlock()
+		lost.Close()
+		if c.cancelling.Load() {
+			// cancelled while resubscribing
+			channel.Cancel(c.consumer, false)
+		}
+		return true
+	}
+}
+
+func (c *defaultBatchConsumer) notifyError(err error) {
+	if c.options.ErrorHandler == nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Printf("defaultBatchConsumer.notifyError panic when notify error handler, panic: %v", p)
+		}
+	}()
+	c.options.ErrorHandler(c.consumer, err)
+}
+
+// deliver batch to handler, then ack or nack the whole batch.
+// return a new empty batch, handler may still hold the delivered one
+func (c *defaultBatchConsumer) flush(batch []*DeliveryMessage) []*DeliveryMessage {
+	if len(batch) == 0 {
+		return batch
+	}
+	last := batch[len(batch)-1]
+	err := c.safeHandle(batch)
+	if err != nil {
+		last.Nack(true, c.options.RequeueOnError)
+	} else {
+		last.Ack(true)
+	}
+	return make([]*DeliveryMessage, 0, c.batchSize)
+}
+
+func (c *defaultBatchConsumer) safeHandle(batch []*DeliveryMessage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("defaultBatchConsumer.handler panic, panic: %v", p)
+		}
+	}()
+	return c.handler(c.ctx, batch)
+}
+
+// stop timer and drain the fired value, so that timer can be safely reset
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package amqpx
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchConsumer_ResubscribeAfterConnectionLost(t *testing.T) {
+	broker := newFakeBroker(t)
+	client := newTestClient(t, broker, WithReconnectBackoff(10*time.Millisecond, 100*time.Millisecond))
+	service := NewAMQPService(client)
+	err := service.QueueDeclare(QueueDeclare{Name: "orders", Durable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first message may be redelivered when its ack is lost with the connection
+	handled := make(map[string]bool)
+	lock := sync.Mutex{}
+	isHandled := func(payload string) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return handled[payload]
+	}
+	consumer, err := service.BatchConsume("orders", "batch", 2, 50*time.Millisecond, func(ctx context.Context, msgs []*DeliveryMessage) error {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, eachMsg := range msgs {
+			var payload string
+			eachMsg.ToValue(&payload)
+			handled[payload] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.Publish("first", WithKey("orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		return isHandled("first")
+	})
+
+	accepted := broker.acceptedConnections()
+	broker.dropConnections()
+	waitFor(t, 5*time.Second, func() bool {
+		return broker.acceptedConnections() > accepted && client.IsConnected()
+	})
+	err = service.Publish("second", WithKey("orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		return isHandled("second")
+	})
+	select {
+	case <-consumer.Done():
+		t.Fatal("expect batch consumer resubscribed after the connection lost")
+	default:
+	}
+	if !service.Health(context.Background()).Healthy {
+		t.Fatal("expect healthy after resubscribed")
+	}
+
+	err = consumer.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-consumer.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect consume loop exited after Stop")
+	}
+}
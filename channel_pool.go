@@ -0,0 +1,137 @@
+package amqpx
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	_defaultChannelPoolSize = 8
+)
+
+// bounded channel pool, it is safe for concurrent use.
+//
+// channels are health checked when borrowed and returned, closed channels are
+// discarded and replaced by new ones on demand
+type channelPool struct {
+	client *AMQPClient
+	// put channel into confirm mode when created
+	confirm bool
+
+	idle chan *amqp.Channel
+	// limit the number of channels, include idle and borrowed
+	slots chan struct{}
+	// confirm trackers of channels in confirm mode, key is *amqp.Channel, value is *confirmTracker
+	trackers sync.Map
+}
+
+func newChannelPool(client *AMQPClient, size int, confirm bool) *channelPool {
+	if size <= 0 {
+		size = _defaultChannelPoolSize
+	}
+	return &channelPool{
+		client:  client,
+		confirm: confirm,
+		idle:    make(chan *amqp.Channel, size),
+		slots:   make(chan struct{}, size),
+	}
+}
+
+// borrow a channel, it waits until a channel is available or ctx is done
+func (p *channelPool) borrow(ctx context.Context) (*amqp.Channel, error) {
+	for {
+		// prefer idle channel
+		select {
+		case ch := <-p.idle:
+			if ch.IsClosed() {
+				p.discard(ch)
+				continue
+			}
+			return ch, nil
+		default:
+		}
+
+		select {
+		case ch := <-p.idle:
+			if ch.IsClosed() {
+				p.discard(ch)
+				continue
+			}
+			return ch, nil
+		case p.slots <- struct{}{}:
+			ch, err := p.newChannel()
+			if err != nil {
+				p.release()
+				return nil, err
+			}
+			return ch, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// give back the channel, when broken is true or the channel is closed, the channel will be discarded
+func (p *channelPool) giveBack(ch *amqp.Channel, broken bool) {
+	if broken || ch.IsClosed() {
+		if !ch.IsClosed() {
+			ch.Close()
+		}
+		p.discard(ch)
+		return
+	}
+	p.idle <- ch
+}
+
+func (p *channelPool) release() {
+	<-p.slots
+}
+
+// release the slot of a closed channel
+func (p *channelPool) discard(ch *amqp.Channel) {
+	p.trackers.Delete(ch)
+	p.release()
+}
+
+// get the confirm tracker of a channel in confirm mode
+func (p *channelPool) tracker(ch *amqp.Channel) *confirmTracker {
+	value, _ := p.trackers.Load(ch)
+	return value.(*confirmTracker)
+}
+
+func (p *channelPool) newChannel() (*amqp.Channel, error) {
+	ch, err := p.client.GetNewPublishChannel()
+	if err != nil {
+		return nil, err
+	}
+	if p.confirm {
+		err = ch.Confirm(false)
+		if err != nil {
+			ch.Close()
+			return nil, err
+		}
+		p.trackers.Store(ch, newConfirmTracker(p.client, ch))
+		return ch, nil
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+	go func() {
+		for eachReturn := range returns {
+			p.client.publishEvent(Event_PublishReturned, &PublishReturnedEvent{
+				Return: eachReturn,
+			})
+		}
+	}()
+	return ch, nil
+}
+
+// is the channel broken by the error, a broken channel should not be reused
+func isChannelBroken(err error) bool {
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		return true
+	}
+	return errors.Is(err, amqp.ErrClosed)
+}
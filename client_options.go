@@ -0,0 +1,89 @@
+package amqpx
+
+import (
+	"strings"
+	"time"
+
+	"github.com/shanluzhineng/amqpx/eventbus"
+)
+
+// ClientOption used to configure AMQPClient
+type ClientOption func(c *AMQPClient)
+
+// set the max number of channels in each publishing channel pool, default is 8.
+// there are separate pools for normal channels and confirm mode channels
+func WithChannelPoolSize(size int) ClientOption {
+	return func(c *AMQPClient) {
+		c.channelPoolSize = size
+	}
+}
+
+// use distinct connections for publishing and consuming.
+//
+// RabbitMQ applies flow control per connection, with separate connections the consumers can still
+// drain queues when publishers are throttled, and each connection recovers independently
+func WithSeparateConnections(separate bool) ClientOption {
+	return func(c *AMQPClient) {
+		c.separateConnections = separate
+	}
+}
+
+// set the backoff used to recover a lost connection, the interval starts from initial
+// and doubles after each failed attempt until max, default is 1s and 30s
+func WithReconnectBackoff(initial time.Duration, max time.Duration) ClientOption {
+	return func(c *AMQPClient) {
+		if initial > 0 {
+			c.reconnectInterval = initial
+		}
+		if max >= initial {
+			c.maxReconnectInterval = max
+		}
+	}
+}
+
+// set cluster node urls, they replace the urls in options.RawUrl.
+// when connecting the nodes are tried one by one until success
+func WithEndpoints(endpoints ...string) ClientOption {
+	return func(c *AMQPClient) {
+		c.endpoints = splitEndpoints(strings.Join(endpoints, ","))
+	}
+}
+
+// try cluster nodes in random order rather than in order when connecting
+func WithShuffleEndpoints(shuffle bool) ClientOption {
+	return func(c *AMQPClient) {
+		c.shuffleEndpoints = shuffle
+	}
+}
+
+// connect with TLS, the url scheme of all endpoints must be amqps, otherwise NewAMQPClient returns error.
+// when options.ExternalAuth is true, authenticate with client certificate by EXTERNAL SASL mechanism
+func WithTLS(options *TLSOptions) ClientOption {
+	return func(c *AMQPClient) {
+		c.tlsOptions = options
+	}
+}
+
+// set connection tuning and client properties, such as heartbeat, frame size and connection name
+func WithConnectionOptions(options *ConnectionOptions) ClientOption {
+	return func(c *AMQPClient) {
+		if options != nil {
+			c.connectionOptions = options
+		}
+	}
+}
+
+// get credentials from provider on each (re)connect instead of the username and password in url,
+// see OAuth2CredentialsProvider
+func WithCredentialsProvider(provider CredentialsProvider) ClientOption {
+	return func(c *AMQPClient) {
+		c.credentialsProvider = provider
+	}
+}
+
+// publish lifecycle events on bus, such as connected, disconnected and blocked, see Event_Connected
+func WithEventBus(bus eventbus.Bus) ClientOption {
+	return func(c *AMQPClient) {
+		c.eventBus = bus
+	}
+}
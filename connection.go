@@ -0,0 +1,388 @@
+package amqpx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	_defaultReconnectInterval    = 1 * time.Second
+	_defaultMaxReconnectInterval = 30 * time.Second
+)
+
+// amqpConnection manage a amqp connection and its default channel.
+//
+// when the connection is lost unexpectedly, it recovers in background independently of other connections
+type amqpConnection struct {
+	// name of connection, such as publisher or consumer
+	name   string
+	client *AMQPClient
+
+	isConnected bool
+	// closed by Close, so it should not be recovered
+	isClosed   bool
+	recovering bool
+	// the dial in progress, nil if not dialing
+	dialing *dialCall
+
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	// the node endpoint of conn
+	endpoint string
+	// the node endpoint of the connection lost unexpectedly, it is tried at last when reconnecting.
+	// it is cleared after close, so that reopen starts from the first node
+	lostEndpoint string
+
+	// blocked by broker flow control, such as memory or disk alarm
+	isBlocked     bool
+	blockedReason string
+	// closed when connection becomes unblocked
+	unblocked chan struct{}
+
+	// protect fields above
+	lock sync.RWMutex
+}
+
+// a dial shared by concurrent connect callers
+type dialCall struct {
+	// closed when the dial finished
+	done chan struct{}
+	err  error
+}
+
+func newAMQPConnection(name string, client *AMQPClient) *amqpConnection {
+	return &amqpConnection{
+		name:   name,
+		client: client,
+	}
+}
+
+// reopen the connection closed by close, then connect
+func (c *amqpConnection) open() error {
+	c.lock.Lock()
+	c.isClosed = false
+	c.lock.Unlock()
+	return c.connect()
+}
+
+// connect to amqp server and create default channel, amqp.ErrClosed is returned after close.
+//
+// it dials without lock held, so the state accessors are not blocked by a slow dial.
+// concurrent callers share the result of the dial in progress
+func (c *amqpConnection) connect() error {
+	c.lock.Lock()
+	if c.isConnected {
+		c.lock.Unlock()
+		return nil
+	}
+	if c.isClosed {
+		c.lock.Unlock()
+		return amqp.ErrClosed
+	}
+	if call := c.dialing; call != nil {
+		c.lock.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &dialCall{done: make(chan struct{})}
+	c.dialing = call
+	c.lock.Unlock()
+
+	connected, err := c.doConnect()
+	call.err = err
+	close(call.done)
+	if connected != nil {
+		c.client.notifyConnected(connected)
+	}
+	return err
+}
+
+// dial without lock held, then swap the new connection in.
+// returns the connected event, it should be published after lock released
+func (c *amqpConnection) doConnect() (*ConnectionEvent, error) {
+	c.lock.RLock()
+	lost := c.lostEndpoint
+	c.lock.RUnlock()
+	conn, endpoint, err := c.client.dial(c.name, lost)
+	var ch *amqp.Channel
+	if err == nil {
+		ch, err = conn.Channel()
+		if err != nil {
+			conn.Close()
+		}
+	}
+
+	c.lock.Lock()
+	c.dialing = nil
+	if err != nil {
+		c.lock.Unlock()
+		return nil, err
+	}
+	if c.isClosed {
+		// closed while dialing
+		c.lock.Unlock()
+		conn.Close()
+		return nil, amqp.ErrClosed
+	}
+	c.conn = conn
+	c.channel = ch
+	c.endpoint = endpoint
+	c.lostEndpoint = ""
+	c.isConnected = true
+	c.lock.Unlock()
+
+	go c.watch(conn, conn.NotifyClose(make(chan *amqp.Error, 1)))
+	go c.watchBlocked(conn, conn.NotifyBlocked(make(chan amqp.Blocking, 1)))
+	return &ConnectionEvent{
+		Connection: c.name,
+		Endpoint:   redactEndpoint(endpoint),
+	}, nil
+}
+
+// close default channel and connection, it will not be recovered
+func (c *amqpConnection) close() error {
+	disconnected, err := c.doClose()
+	if disconnected != nil {
+		// handlers may use the connection, so publish event without lock
+		c.client.publishEvent(Event_Disconnected, disconnected)
+	}
+	return err
+}
+
+// returns the disconnected event when the connection is closed
+func (c *amqpConnection) doClose() (*ConnectionEvent, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.isClosed = true
+	c.lostEndpoint = ""
+	if c.conn == nil || c.conn.IsClosed() {
+		c.reset()
+		return nil, nil
+	}
+	disconnected := &ConnectionEvent{
+		Connection: c.name,
+		Endpoint:   redactEndpoint(c.endpoint),
+	}
+	if c.channel != nil && !c.channel.IsClosed() {
+		err := c.channel.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := c.conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	c.reset()
+	return disconnected, nil
+}
+
+func (c *amqpConnection) connected() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.isConnected
+}
+
+// is closed by close
+func (c *amqpConnection) closed() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.isClosed
+}
+
+// get the underlying connection, nil if not connected
+func (c *amqpConnection) connection() *amqp.Connection {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.conn
+}
+
+// get the default channel, nil if not connected
+//
+// the default channel is reopened when it is closed by a channel exception, such as a failed declare
+func (c *amqpConnection) defaultChannel() *amqp.Channel {
+	c.lock.RLock()
+	channel := c.channel
+	c.lock.RUnlock()
+	if channel == nil || !channel.IsClosed() {
+		return channel
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.channel == nil || !c.channel.IsClosed() || c.conn.IsClosed() {
+		return c.channel
+	}
+	newChannel, err := c.conn.Channel()
+	if err != nil {
+		return c.channel
+	}
+	c.client.channelLocks.Delete(c.channel)
+	c.channel = newChannel
+	return c.channel
+}
+
+// is recovering a lost connection
+func (c *amqpConnection) isRecovering() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.recovering
+}
+
+// the node endpoint currently connected, empty if not connected
+func (c *amqpConnection) currentEndpoint() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.endpoint
+}
+
+// is connection blocked by broker, and the reason
+func (c *amqpConnection) blocked() (bool, string) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.isBlocked, c.blockedReason
+}
+
+// wait until connection is unblocked or ctx is done
+func (c *amqpConnection) waitUnblocked(ctx context.Context) error {
+	c.lock.RLock()
+	isBlocked := c.isBlocked
+	unblocked := c.unblocked
+	c.lock.RUnlock()
+	if !isBlocked {
+		return nil
+	}
+	select {
+	case <-unblocked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// create a new channel on this connection
+func (c *amqpConnection) newChannel() (*amqp.Channel, error) {
+	err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	conn := c.connection()
+	if conn == nil {
+		return nil, amqp.ErrClosed
+	}
+	return conn.Channel()
+}
+
+// reset connection state, must be called with lock held
+func (c *amqpConnection) reset() {
+	if c.channel != nil {
+		c.client.channelLocks.Delete(c.channel)
+	}
+	c.conn = nil
+	c.channel = nil
+	c.endpoint = ""
+	c.isConnected = false
+	c.setBlocked(amqp.Blocking{Active: false})
+}
+
+// must be called with lock held
+func (c *amqpConnection) setBlocked(blocking amqp.Blocking) {
+	if blocking.Active == c.isBlocked {
+		return
+	}
+	c.isBlocked = blocking.Active
+	c.blockedReason = blocking.Reason
+	if blocking.Active {
+		c.unblocked = make(chan struct{})
+	} else {
+		close(c.unblocked)
+	}
+}
+
+// track blocked state until connection closed
+func (c *amqpConnection) watchBlocked(conn *amqp.Connection, blockings chan amqp.Blocking) {
+	for eachBlocking := range blockings {
+		c.lock.Lock()
+		if c.conn != conn {
+			c.lock.Unlock()
+			continue
+		}
+		c.setBlocked(eachBlocking)
+		endpoint := c.endpoint
+		c.lock.Unlock()
+		c.client.notifyBlocked(c.name, eachBlocking)
+
+		event := &ConnectionEvent{
+			Connection: c.name,
+			Endpoint:   redactEndpoint(endpoint),
+			Reason:     eachBlocking.Reason,
+		}
+		if eachBlocking.Active {
+			c.client.publishEvent(Event_Blocked, event)
+		} else {
+			c.client.publishEvent(Event_Unblocked, event)
+		}
+	}
+}
+
+// wait for connection closed, then recover it if it is lost unexpectedly
+func (c *amqpConnection) watch(conn *amqp.Connection, closed chan *amqp.Error) {
+	closeErr, ok := <-closed
+	c.lock.Lock()
+	if c.conn != conn {
+		// already reset by close or replaced by a new connection
+		c.lock.Unlock()
+		return
+	}
+	disconnected := &ConnectionEvent{
+		Connection: c.name,
+		Endpoint:   redactEndpoint(c.endpoint),
+		Err:        amqpError(closeErr),
+	}
+	lostEndpoint := c.endpoint
+	c.reset()
+	if c.isClosed || !ok || closeErr == nil || c.recovering {
+		c.lock.Unlock()
+		c.client.publishEvent(Event_Disconnected, disconnected)
+		return
+	}
+	c.recovering = true
+	c.lostEndpoint = lostEndpoint
+	c.lock.Unlock()
+	c.client.publishEvent(Event_Disconnected, disconnected)
+
+	go c.recover()
+}
+
+// reconnect with exponential backoff until connected or closed
+func (c *amqpConnection) recover() {
+	defer func() {
+		c.lock.Lock()
+		c.recovering = false
+		c.lock.Unlock()
+	}()
+	interval := c.client.reconnectInterval
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		time.Sleep(interval)
+		c.client.publishEvent(Event_Reconnecting, &ConnectionEvent{
+			Connection: c.name,
+			Err:        lastErr,
+			Attempt:    attempt,
+		})
+		// connected by others or closed
+		err := c.connect()
+		if err == nil || errors.Is(err, amqp.ErrClosed) {
+			return
+		}
+		lastErr = err
+		interval *= 2
+		if interval > c.client.maxReconnectInterval {
+			interval = c.client.maxReconnectInterval
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package amqpx
+
+import (
+	"os"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	_defaultHeartbeat = 10 * time.Second
+	_defaultLocale    = "en_US"
+)
+
+// ConnectionOptions configure the connection tuning and the properties advertised to the server,
+// it can be loaded from configuration through configurationx
+type ConnectionOptions struct {
+	// heartbeat interval, less than 1s uses the server's interval, default is 10s
+	Heartbeat time.Duration `mapstructure:"heartbeat"`
+	// connection locale, default is en_US
+	Locale string `mapstructure:"locale"`
+	// max channels, 0 means 2^16 - 1
+	ChannelMax int `mapstructure:"channelMax"`
+	// max frame bytes, 0 means unlimited
+	FrameSize int `mapstructure:"frameSize"`
+
+	// connection_name client property shown in the management UI, default is the app name
+	ConnectionName string `mapstructure:"connectionName"`
+	// additional client properties advertised to the server
+	ClientProperties map[string]interface{} `mapstructure:"clientProperties"`
+}
+
+// build amqp.Config from options, connection parameter is the name of connection, such as consumer or publisher,
+// it is appended to connection name when not empty
+func (o *ConnectionOptions) buildConfig(connection string) amqp.Config {
+	config := amqp.Config{
+		Heartbeat:  _defaultHeartbeat,
+		Locale:     _defaultLocale,
+		ChannelMax: o.ChannelMax,
+		FrameSize:  o.FrameSize,
+		Properties: amqp.NewConnectionProperties(),
+	}
+	if o.Heartbeat != 0 {
+		config.Heartbeat = o.Heartbeat
+	}
+	if o.Locale != "" {
+		config.Locale = o.Locale
+	}
+	for key, value := range o.ClientProperties {
+		config.Properties[key] = value
+	}
+	connectionName := o.ConnectionName
+	if connectionName == "" {
+		connectionName = appName()
+	}
+	if connection != "" {
+		connectionName = connectionName + "-" + connection
+	}
+	config.Properties.SetClientConnectionName(connectionName)
+	return config
+}
+
+// name of current app, it is used as default connection name and consumer name
+func appName() string {
+	return os.Args[0]
+}
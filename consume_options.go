@@ -0,0 +1,115 @@
+package amqpx
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	_defaultResubscribeBackoff = 1 * time.Second
+)
+
+// ErrConsumerCancelled reported to error handler when a consumer is cancelled by server,
+// such as the queue is deleted or the quorum queue leader moves
+var ErrConsumerCancelled = errors.New("consumer is cancelled by server")
+
+// ConsumeOptions configure consumer
+type ConsumeOptions struct {
+	// prefetch count of the consumer channel, 0 means unlimited
+	PrefetchCount int
+	// number of goroutines handling deliveries concurrently, default is 1
+	Concurrency int
+
+	// invoked when consumer has error, such as cancelled by server or resubscribe failed
+	ErrorHandler func(consumer string, err error)
+
+	// resubscribe after cancelled by server
+	Resubscribe bool
+	// wait before resubscribe, it doubles after each failed attempt
+	ResubscribeBackoff time.Duration
+	// declare the queue before resubscribe, because the queue may be deleted
+	Redeclare *QueueDeclare
+}
+
+// ConsumeOption used to configure consumer
+type ConsumeOption func(o *ConsumeOptions)
+
+func newDefaultConsumeOptions() *ConsumeOptions {
+	return &ConsumeOptions{
+		Concurrency:        1,
+		ResubscribeBackoff: _defaultResubscribeBackoff,
+	}
+}
+
+// set prefetch count of the consumer channel, each consumer has its own channel
+func WithPrefetch(prefetchCount int) ConsumeOption {
+	return func(o *ConsumeOptions) {
+		o.PrefetchCount = prefetchCount
+	}
+}
+
+// handle deliveries by concurrency goroutines, observers must be safe for concurrent use.
+// it is recommended to set prefetch count not less than concurrency
+func WithConcurrency(concurrency int) ConsumeOption {
+	return func(o *ConsumeOptions) {
+		if concurrency > 0 {
+			o.Concurrency = concurrency
+		}
+	}
+}
+
+// set handler invoked when consumer has error, such as cancelled by server
+func WithErrorHandler(fn func(consumer string, err error)) ConsumeOption {
+	return func(o *ConsumeOptions) {
+		o.ErrorHandler = fn
+	}
+}
+
+// resubscribe with the same consumer after cancelled by server, it waits backoff before resubscribe
+func WithResubscribe(backoff time.Duration) ConsumeOption {
+	return func(o *ConsumeOptions) {
+		o.Resubscribe = true
+		if backoff > 0 {
+			o.ResubscribeBackoff = backoff
+		}
+	}
+}
+
+// declare the queue before resubscribe
+func WithRedeclare(declare QueueDeclare) ConsumeOption {
+	return func(o *ConsumeOptions) {
+		o.Redeclare = &declare
+	}
+}
+
+// BatchConsumeOptions configure batch consumer
+type BatchConsumeOptions struct {
+	// requeue the batch when handler failed, otherwise it is dropped or dead-lettered. default is true
+	RequeueOnError bool
+	// invoked when consumer has error, such as resubscribe failed
+	ErrorHandler func(consumer string, err error)
+}
+
+// BatchConsumeOption used to configure batch consumer
+type BatchConsumeOption func(o *BatchConsumeOptions)
+
+func newDefaultBatchConsumeOptions() *BatchConsumeOptions {
+	return &BatchConsumeOptions{
+		RequeueOnError: true,
+	}
+}
+
+// requeue the batch when handler failed. set it false with a dead letter exchange on the queue,
+// so that a poison batch is not redelivered forever
+func WithBatchRequeueOnError(requeue bool) BatchConsumeOption {
+	return func(o *BatchConsumeOptions) {
+		o.RequeueOnError = requeue
+	}
+}
+
+// set handler invoked when batch consumer has error, such as resubscribe failed after the connection is lost
+func WithBatchErrorHandler(fn func(consumer string, err error)) BatchConsumeOption {
+	return func(o *BatchConsumeOptions) {
+		o.ErrorHandler = fn
+	}
+}
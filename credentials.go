@@ -0,0 +1,100 @@
+package amqpx
+
+import (
+	"context"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// refresh credentials before they expire
+	_defaultRefreshBefore = 1 * time.Minute
+	// retry interval when refresh credentials failed
+	_refreshRetryInterval = 5 * time.Second
+)
+
+// Credentials used to authenticate the connection
+type Credentials struct {
+	Username string
+	// password or token
+	Password string
+	// when the credentials expire, zero means never expire
+	ExpiresAt time.Time
+	// when the credentials should be refreshed, zero means 1 minute before ExpiresAt.
+	// providers set it by refreshTime so the rotator and provider share the same window
+	RefreshAt time.Time
+}
+
+// duration until the credentials should be refreshed, at least _refreshRetryInterval
+func (c *Credentials) refreshDelay() time.Duration {
+	refreshAt := c.RefreshAt
+	if refreshAt.IsZero() {
+		refreshAt = refreshTime(time.Now(), c.ExpiresAt, _defaultRefreshBefore)
+	}
+	delay := time.Until(refreshAt)
+	if delay < _refreshRetryInterval {
+		delay = _refreshRetryInterval
+	}
+	return delay
+}
+
+// refresh refreshBefore ahead of expiry, but not earlier than half of the remaining lifetime,
+// so short-lived credentials are not refreshed in a busy loop
+func refreshTime(now time.Time, expiresAt time.Time, refreshBefore time.Duration) time.Time {
+	ahead := refreshBefore
+	if half := expiresAt.Sub(now) / 2; ahead > half {
+		ahead = half
+	}
+	return expiresAt.Add(-ahead)
+}
+
+// CredentialsProvider provide credentials for connection, it is consulted on each (re)connect.
+//
+// when the credentials expire, the provider is consulted again and the new password is
+// rotated on live connections by Connection.UpdateSecret without reconnecting
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (*Credentials, error)
+}
+
+// get credentials from provider, nil if no provider
+func (c *AMQPClient) credentials() (*Credentials, error) {
+	if c.credentialsProvider == nil {
+		return nil, nil
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), _defaultTimeout)
+	defer cancelFunc()
+	return c.credentialsProvider.Credentials(ctx)
+}
+
+// rotate the secret of conn before credentials expire, until conn closed
+func (c *AMQPClient) rotateSecret(conn *amqp.Connection, credentials *Credentials) {
+	if credentials == nil || credentials.ExpiresAt.IsZero() {
+		return
+	}
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	timer := time.NewTimer(credentials.refreshDelay())
+	defer timer.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-timer.C:
+		}
+		newCredentials, err := c.credentials()
+		if err != nil || newCredentials == nil || newCredentials.Password == credentials.Password {
+			timer.Reset(_refreshRetryInterval)
+			continue
+		}
+		err = conn.UpdateSecret(newCredentials.Password, "credentials refreshed")
+		if err != nil {
+			timer.Reset(_refreshRetryInterval)
+			continue
+		}
+		credentials = newCredentials
+		if credentials.ExpiresAt.IsZero() {
+			return
+		}
+		timer.Reset(credentials.refreshDelay())
+	}
+}
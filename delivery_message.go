@@ -7,6 +7,8 @@ import (
 type DeliveryMessage struct {
 	_underlyingDelivery *amqp.Delivery
 	unmarshal           func([]byte, interface{}) error
+	// invoked after the message is acked (true) or nacked/rejected (false)
+	settledHooks []func(ack bool)
 }
 
 func newDeliveryMessage(underlyingDelivery *amqp.Delivery) *DeliveryMessage {
@@ -21,6 +23,36 @@ func (m *DeliveryMessage) Payload() []byte {
 	return m._underlyingDelivery.Body
 }
 
+// application provided message id, empty if not set by publisher
+func (m *DeliveryMessage) MessageId() string {
+	return m._underlyingDelivery.MessageId
+}
+
+// application provided correlation id
+func (m *DeliveryMessage) CorrelationId() string {
+	return m._underlyingDelivery.CorrelationId
+}
+
+// message headers
+func (m *DeliveryMessage) Headers() map[string]interface{} {
+	return m._underlyingDelivery.Headers
+}
+
+// the exchange the message was published to
+func (m *DeliveryMessage) Exchange() string {
+	return m._underlyingDelivery.Exchange
+}
+
+// the routing key the message was published with
+func (m *DeliveryMessage) RoutingKey() string {
+	return m._underlyingDelivery.RoutingKey
+}
+
+// is the message delivered before, such as requeued or redelivered after reconnect
+func (m *DeliveryMessage) Redelivered() bool {
+	return m._underlyingDelivery.Redelivered
+}
+
 // convert Payload to value through json unmarsh
 func (m *DeliveryMessage) ToValue(v interface{}) error {
 	if len(m._underlyingDelivery.Body) <= 0 {
@@ -47,7 +79,9 @@ func (m *DeliveryMessage) ToValue(v interface{}) error {
 // Either Delivery.Ack, Delivery.Reject or Delivery.Nack must be called for every
 // delivery that is not automatically acknowledged.
 func (m *DeliveryMessage) Ack(multiple bool) error {
-	return m._underlyingDelivery.Ack(multiple)
+	err := m._underlyingDelivery.Ack(multiple)
+	m.settled(err == nil)
+	return err
 }
 
 // Reject delegates a negatively acknowledgement through the Acknowledger interface.
@@ -62,7 +96,9 @@ func (m *DeliveryMessage) Ack(multiple bool) error {
 // Either Delivery.Ack, Delivery.Reject or Delivery.Nack must be called for every
 // delivery that is not automatically acknowledged.
 func (m *DeliveryMessage) Reject(requeue bool) error {
-	return m._underlyingDelivery.Reject(requeue)
+	err := m._underlyingDelivery.Reject(requeue)
+	m.settled(false)
+	return err
 }
 
 // Nack negatively acknowledge the delivery of message(s) identified by the
@@ -82,5 +118,18 @@ func (m *DeliveryMessage) Reject(requeue bool) error {
 // Either Delivery.Ack, Delivery.Reject or Delivery.Nack must be called for every
 // delivery that is not automatically acknowledged.
 func (m *DeliveryMessage) Nack(multiple, requeue bool) error {
-	return m._underlyingDelivery.Nack(multiple, requeue)
+	err := m._underlyingDelivery.Nack(multiple, requeue)
+	m.settled(false)
+	return err
+}
+
+// register fn invoked after the message is acked or nacked
+func (m *DeliveryMessage) onSettled(fn func(ack bool)) {
+	m.settledHooks = append(m.settledHooks, fn)
+}
+
+func (m *DeliveryMessage) settled(ack bool) {
+	for _, eachHook := range m.settledHooks {
+		eachHook(ack)
+	}
 }
@@ -0,0 +1,79 @@
+package amqpx
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// lifecycle event topics published on eventbus.Bus, see WithEventBus
+const (
+	// a connection is established, handler is func(e *ConnectionEvent)
+	Event_Connected = "amqpx.connected"
+	// a connection is closed or lost, handler is func(e *ConnectionEvent)
+	Event_Disconnected = "amqpx.disconnected"
+	// recovering a lost connection, handler is func(e *ConnectionEvent)
+	Event_Reconnecting = "amqpx.reconnecting"
+	// a connection is blocked by broker, handler is func(e *ConnectionEvent)
+	Event_Blocked = "amqpx.blocked"
+	// a connection is unblocked by broker, handler is func(e *ConnectionEvent)
+	Event_Unblocked = "amqpx.unblocked"
+	// a channel is closed, handler is func(e *ChannelClosedEvent)
+	Event_ChannelClosed = "amqpx.channel_closed"
+	// a consumer is cancelled by server, such as queue deleted, handler is func(e *ConsumerCancelledEvent)
+	Event_ConsumerCancelled = "amqpx.consumer_cancelled"
+	// a mandatory or immediate message is returned by server, handler is func(e *PublishReturnedEvent)
+	Event_PublishReturned = "amqpx.publish_returned"
+)
+
+// ConnectionEvent is the argument of connection lifecycle events
+type ConnectionEvent struct {
+	// name of connection, consumer or publisher
+	Connection string
+	// node endpoint, password is redacted
+	Endpoint string
+	// the error caused disconnected, or the error of last reconnecting attempt
+	Err error
+	// reconnecting attempt, starts from 1
+	Attempt int
+	// blocked reason
+	Reason string
+}
+
+// ChannelClosedEvent is the argument of Event_ChannelClosed
+type ChannelClosedEvent struct {
+	Channel *amqp.Channel
+	// nil when the channel is closed by client
+	Err error
+}
+
+// ConsumerCancelledEvent is the argument of Event_ConsumerCancelled
+type ConsumerCancelledEvent struct {
+	Consumer string
+}
+
+// PublishReturnedEvent is the argument of Event_PublishReturned
+type PublishReturnedEvent struct {
+	Return amqp.Return
+}
+
+// publish event on event bus, it does nothing when no event bus
+func (c *AMQPClient) publishEvent(topic string, event interface{}) {
+	if c.eventBus == nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Printf("AMQPClient.publishEvent panic when publish event %s, panic: %v", topic, p)
+		}
+	}()
+	c.eventBus.Publish(topic, event)
+}
+
+// convert *amqp.Error to error, nil *amqp.Error is converted to nil error
+func amqpError(err *amqp.Error) error {
+	if err == nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,852 @@
+package amqpx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	_fakeFrameMethod    = 1
+	_fakeFrameHeader    = 2
+	_fakeFrameBody      = 3
+	_fakeFrameHeartbeat = 8
+	_fakeFrameEnd       = 0xCE
+	_fakeFrameMax       = 131072
+)
+
+// fakeBroker is a minimal in-process amqp 0-9-1 server used by tests.
+//
+// it supports declaring and binding direct, fanout and topic exchanges, publishing with
+// confirms and returns, consuming, getting and acking. messages are kept in memory, prefetch is ignored
+type fakeBroker struct {
+	listener net.Listener
+
+	// exchange kind by name
+	exchanges map[string]string
+	// bindings by exchange
+	bindings map[string][]fakeBinding
+	queues   map[string]*fakeQueue
+	conns    map[*fakeConn]struct{}
+	// number of connections accepted
+	accepted int
+	// used to generate queue names and consumer tags
+	sequence int
+	// protect fields above
+	lock sync.Mutex
+	wg   sync.WaitGroup
+}
+
+type fakeBinding struct {
+	queue string
+	key   string
+}
+
+type fakeQueue struct {
+	name       string
+	autoDelete bool
+	// the connection declared the exclusive queue, nil if not exclusive
+	owner     *fakeConn
+	messages  []*fakeMessage
+	consumers []*fakeConsumer
+	// round robin index of consumers
+	next int
+}
+
+type fakeMessage struct {
+	exchange    string
+	key         string
+	redelivered bool
+	// raw payload of the content header frame, it is the same for basic.publish and basic.deliver
+	header []byte
+	body   []byte
+}
+
+type fakeConsumer struct {
+	tag     string
+	noAck   bool
+	queue   *fakeQueue
+	channel *fakeChannel
+}
+
+type fakeConn struct {
+	broker *fakeBroker
+	conn   net.Conn
+	// protected by broker.lock
+	channels map[uint16]*fakeChannel
+	// serialize frames written to conn
+	writeLock sync.Mutex
+}
+
+type fakeChannel struct {
+	id   uint16
+	conn *fakeConn
+
+	confirm   bool
+	published uint64
+	// last delivery tag sent to consumers
+	deliveryTag uint64
+	unacked     map[uint64]*fakeUnacked
+	consumers   map[string]*fakeConsumer
+	// the publishing waiting for content frames
+	publishing *fakePublishing
+	// closed by server, waiting for close-ok of client
+	closing bool
+}
+
+type fakeUnacked struct {
+	queue *fakeQueue
+	msg   *fakeMessage
+}
+
+type fakePublishing struct {
+	msg       *fakeMessage
+	mandatory bool
+	size      uint64
+}
+
+func newFakeChannel(id uint16, conn *fakeConn) *fakeChannel {
+	return &fakeChannel{
+		id:        id,
+		conn:      conn,
+		unacked:   make(map[uint64]*fakeUnacked),
+		consumers: make(map[string]*fakeConsumer),
+	}
+}
+
+// start a broker listening on a random local port, it is closed when the test finishes
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{
+		listener:  listener,
+		exchanges: make(map[string]string),
+		bindings:  make(map[string][]fakeBinding),
+		queues:    make(map[string]*fakeQueue),
+		conns:     make(map[*fakeConn]struct{}),
+	}
+	b.wg.Add(1)
+	go b.accept()
+	t.Cleanup(b.close)
+	return b
+}
+
+func (b *fakeBroker) url() string {
+	return "amqp://guest:guest@" + b.listener.Addr().String() + "/"
+}
+
+// number of connections accepted since started
+func (b *fakeBroker) acceptedConnections() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.accepted
+}
+
+// number of open connections
+func (b *fakeBroker) connections() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.conns)
+}
+
+// number of ready messages in queue and number of deliveries not acked on all channels
+func (b *fakeBroker) messages(queue string) (int, int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ready := 0
+	if q, ok := b.queues[queue]; ok {
+		ready = len(q.messages)
+	}
+	unacked := 0
+	for eachConn := range b.conns {
+		for _, eachChannel := range eachConn.channels {
+			unacked += len(eachChannel.unacked)
+		}
+	}
+	return ready, unacked
+}
+
+func (b *fakeBroker) close() {
+	b.listener.Close()
+	b.lock.Lock()
+	for eachConn := range b.conns {
+		eachConn.conn.Close()
+	}
+	b.lock.Unlock()
+	b.wg.Wait()
+}
+
+// close all connections abruptly, like the node is restarted
+func (b *fakeBroker) dropConnections() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for eachConn := range b.conns {
+		eachConn.conn.Close()
+	}
+}
+
+// cancel the consumers of queue by server, like the queue is deleted on another node
+func (b *fakeBroker) cancelConsumers(queue string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	q, ok := b.queues[queue]
+	if !ok {
+		return
+	}
+	for _, eachConsumer := range append([]*fakeConsumer(nil), q.consumers...) {
+		b.cancel(eachConsumer)
+		args := &fakeArgs{}
+		args.shortstr(eachConsumer.tag)
+		args.bits(true)
+		eachConsumer.channel.conn.writeMethod(eachConsumer.channel.id, 60, 30, args)
+	}
+}
+
+func (b *fakeBroker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &fakeConn{
+			broker:   b,
+			conn:     conn,
+			channels: make(map[uint16]*fakeChannel),
+		}
+		b.lock.Lock()
+		b.conns[c] = struct{}{}
+		b.accepted++
+		b.lock.Unlock()
+		b.wg.Add(1)
+		go c.serve()
+	}
+}
+
+func (b *fakeBroker) nextName(prefix string) string {
+	b.sequence++
+	return fmt.Sprintf("%s%d", prefix, b.sequence)
+}
+
+// queues the message routed to, must be called with lock held
+func (b *fakeBroker) route(exchange string, key string) []*fakeQueue {
+	if exchange == "" {
+		if q, ok := b.queues[key]; ok {
+			return []*fakeQueue{q}
+		}
+		return nil
+	}
+	var queues []*fakeQueue
+	for _, eachBinding := range b.bindings[exchange] {
+		q, ok := b.queues[eachBinding.queue]
+		if !ok {
+			continue
+		}
+		switch b.exchanges[exchange] {
+		case string(Exchange_Fanout):
+		case string(Exchange_Topic):
+			if !topicMatch(strings.Split(eachBinding.key, "."), strings.Split(key, ".")) {
+				continue
+			}
+		default:
+			if eachBinding.key != key {
+				continue
+			}
+		}
+		queues = append(queues, q)
+	}
+	return queues
+}
+
+// deliver messages to consumers of q in round robin, must be called with lock held
+func (b *fakeBroker) dispatch(q *fakeQueue) {
+	for len(q.messages) > 0 && len(q.consumers) > 0 {
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		q.next = (q.next + 1) % len(q.consumers)
+		consumer := q.consumers[q.next]
+		ch := consumer.channel
+		ch.deliveryTag++
+		if !consumer.noAck {
+			ch.unacked[ch.deliveryTag] = &fakeUnacked{queue: q, msg: msg}
+		}
+		args := &fakeArgs{}
+		args.shortstr(consumer.tag)
+		args.longlong(ch.deliveryTag)
+		args.bits(msg.redelivered)
+		args.shortstr(msg.exchange)
+		args.shortstr(msg.key)
+		ch.conn.writeContent(ch.id, 60, 60, args, msg)
+	}
+}
+
+// put message back to the head of queue, must be called with lock held
+func (b *fakeBroker) requeue(q *fakeQueue, msg *fakeMessage) {
+	if _, ok := b.queues[q.name]; !ok {
+		return
+	}
+	msg.redelivered = true
+	q.messages = append([]*fakeMessage{msg}, q.messages...)
+}
+
+// remove consumer, the auto-delete queue is deleted with its last consumer. must be called with lock held
+func (b *fakeBroker) cancel(consumer *fakeConsumer) {
+	q := consumer.queue
+	delete(consumer.channel.consumers, consumer.tag)
+	for i, eachConsumer := range q.consumers {
+		if eachConsumer == consumer {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.autoDelete && len(q.consumers) <= 0 {
+		b.deleteQueue(q)
+	}
+}
+
+// must be called with lock held
+func (b *fakeBroker) deleteQueue(q *fakeQueue) {
+	delete(b.queues, q.name)
+	for eachExchange, eachBindings := range b.bindings {
+		kept := eachBindings[:0]
+		for _, eachBinding := range eachBindings {
+			if eachBinding.queue != q.name {
+				kept = append(kept, eachBinding)
+			}
+		}
+		b.bindings[eachExchange] = kept
+	}
+}
+
+func topicMatch(pattern []string, key []string) bool {
+	if len(pattern) <= 0 {
+		return len(key) <= 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if topicMatch(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && topicMatch(pattern[1:], key[1:])
+	}
+	return len(key) > 0 && pattern[0] == key[0] && topicMatch(pattern[1:], key[1:])
+}
+
+func (c *fakeConn) serve() {
+	defer c.broker.wg.Done()
+	defer c.shutdown()
+	reader := bufio.NewReader(c.conn)
+	protocol := make([]byte, 8)
+	_, err := io.ReadFull(reader, protocol)
+	if err != nil || !bytes.Equal(protocol, []byte("AMQP\x00\x00\x09\x01")) {
+		return
+	}
+	start := &fakeArgs{}
+	start.octet(0)
+	start.octet(9)
+	start.emptyTable()
+	start.longstr("PLAIN AMQPLAIN")
+	start.longstr("en_US")
+	c.writeMethod(0, 10, 10, start)
+	for {
+		frameType, channel, payload, err := readFakeFrame(reader)
+		if err != nil {
+			return
+		}
+		switch frameType {
+		case _fakeFrameHeartbeat:
+			c.writeFrames(fakeFrame(_fakeFrameHeartbeat, 0, nil))
+		case _fakeFrameMethod:
+			if !c.handleMethod(channel, payload) {
+				return
+			}
+		case _fakeFrameHeader, _fakeFrameBody:
+			c.handleContent(channel, frameType, payload)
+		}
+	}
+}
+
+// handle a method frame, returns false when the connection is closed
+func (c *fakeConn) handleMethod(channel uint16, payload []byte) bool {
+	args := &fakeReader{data: payload}
+	classId, methodId := args.short(), args.short()
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if classId == 10 {
+		switch methodId {
+		case 11: // start-ok
+			tune := &fakeArgs{}
+			tune.short(2047)
+			tune.long(_fakeFrameMax)
+			tune.short(0)
+			c.writeMethod(0, 10, 30, tune)
+		case 40: // open
+			openOk := &fakeArgs{}
+			openOk.shortstr("")
+			c.writeMethod(0, 10, 41, openOk)
+		case 50: // close
+			c.writeMethod(0, 10, 51, &fakeArgs{})
+			return false
+		case 51: // close-ok
+			return false
+		}
+		return true
+	}
+	if classId == 20 && methodId == 10 {
+		c.channels[channel] = newFakeChannel(channel, c)
+		openOk := &fakeArgs{}
+		openOk.longstr("")
+		c.writeMethod(channel, 20, 11, openOk)
+		return true
+	}
+	ch, ok := c.channels[channel]
+	if !ok {
+		return true
+	}
+	method := uint32(classId)<<16 | uint32(methodId)
+	if ch.closing && method != 20<<16|40 && method != 20<<16|41 {
+		// discard the frames sent before client received channel.close
+		return true
+	}
+	switch method {
+	case 20<<16 | 40: // channel.close
+		c.closeChannel(ch)
+		c.writeMethod(channel, 20, 41, &fakeArgs{})
+	case 20<<16 | 41: // channel.close-ok
+		c.closeChannel(ch)
+	case 40<<16 | 10: // exchange.declare
+		args.short()
+		name, kind := args.shortstr(), args.shortstr()
+		flags := args.octet()
+		if _, ok := b.exchanges[name]; !ok {
+			if flags&1 != 0 {
+				c.channelError(ch, 404, "NOT_FOUND - no exchange '"+name+"'", classId, methodId)
+				return true
+			}
+			b.exchanges[name] = kind
+		}
+		if flags&16 == 0 {
+			c.writeMethod(channel, 40, 11, &fakeArgs{})
+		}
+	case 50<<16 | 10: // queue.declare
+		args.short()
+		name := args.shortstr()
+		flags := args.octet()
+		if name == "" {
+			name = b.nextName("amq.gen-")
+		}
+		q, ok := b.queues[name]
+		if !ok {
+			if flags&1 != 0 {
+				c.channelError(ch, 404, "NOT_FOUND - no queue '"+name+"'", classId, methodId)
+				return true
+			}
+			q = &fakeQueue{name: name, autoDelete: flags&8 != 0}
+			if flags&4 != 0 {
+				q.owner = c
+			}
+			b.queues[name] = q
+		}
+		if flags&16 == 0 {
+			declareOk := &fakeArgs{}
+			declareOk.shortstr(name)
+			declareOk.long(uint32(len(q.messages)))
+			declareOk.long(uint32(len(q.consumers)))
+			c.writeMethod(channel, 50, 11, declareOk)
+		}
+	case 50<<16 | 20: // queue.bind
+		args.short()
+		queue, exchange, key := args.shortstr(), args.shortstr(), args.shortstr()
+		flags := args.octet()
+		if _, ok := b.queues[queue]; !ok {
+			c.channelError(ch, 404, "NOT_FOUND - no queue '"+queue+"'", classId, methodId)
+			return true
+		}
+		if _, ok := b.exchanges[exchange]; !ok {
+			c.channelError(ch, 404, "NOT_FOUND - no exchange '"+exchange+"'", classId, methodId)
+			return true
+		}
+		binding := fakeBinding{queue: queue, key: key}
+		exists := false
+		for _, eachBinding := range b.bindings[exchange] {
+			exists = exists || eachBinding == binding
+		}
+		if !exists {
+			b.bindings[exchange] = append(b.bindings[exchange], binding)
+		}
+		if flags&1 == 0 {
+			c.writeMethod(channel, 50, 21, &fakeArgs{})
+		}
+	case 60<<16 | 10: // basic.qos
+		c.writeMethod(channel, 60, 11, &fakeArgs{})
+	case 60<<16 | 20: // basic.consume
+		args.short()
+		queue, tag := args.shortstr(), args.shortstr()
+		flags := args.octet()
+		q, ok := b.queues[queue]
+		if !ok {
+			c.channelError(ch, 404, "NOT_FOUND - no queue '"+queue+"'", classId, methodId)
+			return true
+		}
+		if tag == "" {
+			tag = b.nextName("amq.ctag-")
+		}
+		consumer := &fakeConsumer{tag: tag, noAck: flags&2 != 0, queue: q, channel: ch}
+		ch.consumers[tag] = consumer
+		q.consumers = append(q.consumers, consumer)
+		if flags&8 == 0 {
+			consumeOk := &fakeArgs{}
+			consumeOk.shortstr(tag)
+			c.writeMethod(channel, 60, 21, consumeOk)
+		}
+		b.dispatch(q)
+	case 60<<16 | 30: // basic.cancel
+		tag := args.shortstr()
+		flags := args.octet()
+		if consumer, ok := ch.consumers[tag]; ok {
+			b.cancel(consumer)
+		}
+		if flags&1 == 0 {
+			cancelOk := &fakeArgs{}
+			cancelOk.shortstr(tag)
+			c.writeMethod(channel, 60, 31, cancelOk)
+		}
+	case 60<<16 | 40: // basic.publish
+		args.short()
+		exchange, key := args.shortstr(), args.shortstr()
+		flags := args.octet()
+		if _, ok := b.exchanges[exchange]; !ok && exchange != "" {
+			c.channelError(ch, 404, "NOT_FOUND - no exchange '"+exchange+"'", classId, methodId)
+			return true
+		}
+		ch.publishing = &fakePublishing{
+			msg:       &fakeMessage{exchange: exchange, key: key},
+			mandatory: flags&1 != 0,
+		}
+	case 60<<16 | 70: // basic.get
+		args.short()
+		queue := args.shortstr()
+		flags := args.octet()
+		q, ok := b.queues[queue]
+		if !ok {
+			c.channelError(ch, 404, "NOT_FOUND - no queue '"+queue+"'", classId, methodId)
+			return true
+		}
+		if len(q.messages) == 0 {
+			getEmpty := &fakeArgs{}
+			getEmpty.shortstr("")
+			c.writeMethod(channel, 60, 72, getEmpty)
+			return true
+		}
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		ch.deliveryTag++
+		if flags&1 == 0 {
+			ch.unacked[ch.deliveryTag] = &fakeUnacked{queue: q, msg: msg}
+		}
+		getOk := &fakeArgs{}
+		getOk.longlong(ch.deliveryTag)
+		getOk.bits(msg.redelivered)
+		getOk.shortstr(msg.exchange)
+		getOk.shortstr(msg.key)
+		getOk.long(uint32(len(q.messages)))
+		c.writeContent(channel, 60, 71, getOk, msg)
+	case 60<<16 | 80: // basic.ack
+		tag := args.longlong()
+		flags := args.octet()
+		c.settle(ch, tag, flags&1 != 0, false)
+	case 60<<16 | 90: // basic.reject
+		tag := args.longlong()
+		flags := args.octet()
+		c.settle(ch, tag, false, flags&1 != 0)
+	case 60<<16 | 120: // basic.nack
+		tag := args.longlong()
+		flags := args.octet()
+		c.settle(ch, tag, flags&1 != 0, flags&2 != 0)
+	case 85<<16 | 10: // confirm.select
+		flags := args.octet()
+		ch.confirm = true
+		if flags&1 == 0 {
+			c.writeMethod(channel, 85, 11, &fakeArgs{})
+		}
+	default:
+		c.channelError(ch, 540, fmt.Sprintf("NOT_IMPLEMENTED - method %d.%d", classId, methodId), classId, methodId)
+	}
+	return true
+}
+
+func (c *fakeConn) handleContent(channel uint16, frameType byte, payload []byte) {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ch, ok := c.channels[channel]
+	if !ok || ch.closing || ch.publishing == nil {
+		return
+	}
+	publishing := ch.publishing
+	if frameType == _fakeFrameHeader {
+		publishing.msg.header = payload
+		publishing.size = binary.BigEndian.Uint64(payload[4:12])
+	} else {
+		publishing.msg.body = append(publishing.msg.body, payload...)
+	}
+	if uint64(len(publishing.msg.body)) < publishing.size {
+		return
+	}
+	ch.publishing = nil
+	msg := publishing.msg
+	queues := b.route(msg.exchange, msg.key)
+	for _, eachQueue := range queues {
+		copied := *msg
+		eachQueue.messages = append(eachQueue.messages, &copied)
+		b.dispatch(eachQueue)
+	}
+	if len(queues) <= 0 && publishing.mandatory {
+		ret := &fakeArgs{}
+		ret.short(312)
+		ret.shortstr("NO_ROUTE")
+		ret.shortstr(msg.exchange)
+		ret.shortstr(msg.key)
+		c.writeContent(channel, 60, 50, ret, msg)
+	}
+	if ch.confirm {
+		ch.published++
+		ack := &fakeArgs{}
+		ack.longlong(ch.published)
+		ack.bits(false)
+		c.writeMethod(channel, 60, 80, ack)
+	}
+}
+
+// ack, nack or reject deliveries, must be called with lock held
+func (c *fakeConn) settle(ch *fakeChannel, tag uint64, multiple bool, requeue bool) {
+	tags := []uint64{tag}
+	if multiple {
+		tags = tags[:0]
+		for eachTag := range ch.unacked {
+			if eachTag <= tag || tag == 0 {
+				tags = append(tags, eachTag)
+			}
+		}
+	}
+	for _, eachTag := range tags {
+		unacked, ok := ch.unacked[eachTag]
+		if !ok {
+			continue
+		}
+		delete(ch.unacked, eachTag)
+		if requeue {
+			c.broker.requeue(unacked.queue, unacked.msg)
+			c.broker.dispatch(unacked.queue)
+		}
+	}
+}
+
+// close channel by server with a soft error, such as 404
+func (c *fakeConn) channelError(ch *fakeChannel, code uint16, text string, classId uint16, methodId uint16) {
+	c.closeChannel(ch)
+	args := &fakeArgs{}
+	args.short(code)
+	args.shortstr(text)
+	args.short(classId)
+	args.short(methodId)
+	c.writeMethod(ch.id, 20, 40, args)
+	// accept the close-ok of client
+	closing := newFakeChannel(ch.id, c)
+	closing.closing = true
+	c.channels[ch.id] = closing
+}
+
+// cancel consumers and requeue unacked deliveries, must be called with lock held
+func (c *fakeConn) closeChannel(ch *fakeChannel) {
+	delete(c.channels, ch.id)
+	for _, eachConsumer := range ch.consumers {
+		c.broker.cancel(eachConsumer)
+	}
+	requeued := make(map[*fakeQueue]struct{})
+	for eachTag := uint64(1); eachTag <= ch.deliveryTag; eachTag++ {
+		if unacked, ok := ch.unacked[eachTag]; ok {
+			c.broker.requeue(unacked.queue, unacked.msg)
+			requeued[unacked.queue] = struct{}{}
+		}
+	}
+	ch.unacked = make(map[uint64]*fakeUnacked)
+	for eachQueue := range requeued {
+		c.broker.dispatch(eachQueue)
+	}
+}
+
+func (c *fakeConn) shutdown() {
+	c.conn.Close()
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, eachChannel := range c.channels {
+		c.closeChannel(eachChannel)
+	}
+	for _, eachQueue := range b.queues {
+		if eachQueue.owner == c {
+			b.deleteQueue(eachQueue)
+		}
+	}
+	delete(b.conns, c)
+}
+
+func (c *fakeConn) writeMethod(channel uint16, classId uint16, methodId uint16, args *fakeArgs) {
+	c.writeFrames(methodFrame(channel, classId, methodId, args))
+}
+
+// write method with content, the frames are written together so they are not interleaved
+func (c *fakeConn) writeContent(channel uint16, classId uint16, methodId uint16, args *fakeArgs, msg *fakeMessage) {
+	frames := methodFrame(channel, classId, methodId, args)
+	frames = append(frames, fakeFrame(_fakeFrameHeader, channel, msg.header)...)
+	for body := msg.body; len(body) > 0; {
+		size := len(body)
+		if size > _fakeFrameMax-8 {
+			size = _fakeFrameMax - 8
+		}
+		frames = append(frames, fakeFrame(_fakeFrameBody, channel, body[:size])...)
+		body = body[size:]
+	}
+	c.writeFrames(frames)
+}
+
+func (c *fakeConn) writeFrames(frames []byte) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	// the connection may be closed by client, it is detected by the reader
+	c.conn.Write(frames)
+}
+
+func methodFrame(channel uint16, classId uint16, methodId uint16, args *fakeArgs) []byte {
+	payload := &fakeArgs{}
+	payload.short(classId)
+	payload.short(methodId)
+	payload.Write(args.Bytes())
+	return fakeFrame(_fakeFrameMethod, channel, payload.Bytes())
+}
+
+func fakeFrame(frameType byte, channel uint16, payload []byte) []byte {
+	data := make([]byte, 7, 8+len(payload))
+	data[0] = frameType
+	binary.BigEndian.PutUint16(data[1:3], channel)
+	binary.BigEndian.PutUint32(data[3:7], uint32(len(payload)))
+	data = append(data, payload...)
+	return append(data, _fakeFrameEnd)
+}
+
+func readFakeFrame(reader *bufio.Reader) (byte, uint16, []byte, error) {
+	header := make([]byte, 7)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[3:7])+1)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if payload[len(payload)-1] != _fakeFrameEnd {
+		return 0, 0, nil, errors.New("invalid frame end")
+	}
+	return header[0], binary.BigEndian.Uint16(header[1:3]), payload[:len(payload)-1], nil
+}
+
+// encode method arguments
+type fakeArgs struct {
+	bytes.Buffer
+}
+
+func (a *fakeArgs) octet(v byte) {
+	a.WriteByte(v)
+}
+
+func (a *fakeArgs) short(v uint16) {
+	binary.Write(a, binary.BigEndian, v)
+}
+
+func (a *fakeArgs) long(v uint32) {
+	binary.Write(a, binary.BigEndian, v)
+}
+
+func (a *fakeArgs) longlong(v uint64) {
+	binary.Write(a, binary.BigEndian, v)
+}
+
+func (a *fakeArgs) shortstr(v string) {
+	a.octet(byte(len(v)))
+	a.WriteString(v)
+}
+
+func (a *fakeArgs) longstr(v string) {
+	a.long(uint32(len(v)))
+	a.WriteString(v)
+}
+
+func (a *fakeArgs) emptyTable() {
+	a.long(0)
+}
+
+func (a *fakeArgs) bits(bits ...bool) {
+	var v byte
+	for i, eachBit := range bits {
+		if eachBit {
+			v |= 1 << i
+		}
+	}
+	a.octet(v)
+}
+
+// decode method arguments, tables are skipped by the callers not reading them
+type fakeReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *fakeReader) octet() byte {
+	if r.pos >= len(r.data) {
+		return 0
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *fakeReader) short() uint16 {
+	if r.pos+2 > len(r.data) {
+		return 0
+	}
+	r.pos += 2
+	return binary.BigEndian.Uint16(r.data[r.pos-2 : r.pos])
+}
+
+func (r *fakeReader) longlong() uint64 {
+	if r.pos+8 > len(r.data) {
+		return 0
+	}
+	r.pos += 8
+	return binary.BigEndian.Uint64(r.data[r.pos-8 : r.pos])
+}
+
+func (r *fakeReader) shortstr() string {
+	size := int(r.octet())
+	if r.pos+size > len(r.data) {
+		return ""
+	}
+	r.pos += size
+	return string(r.data[r.pos-size : r.pos])
+}
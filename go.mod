@@ -3,6 +3,7 @@ module github.com/shanluzhineng/amqpx
 go 1.22
 
 require (
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/rabbitmq/amqp091-go v1.7.0
 	github.com/shanluzhineng/configurationx v0.0.1
 )
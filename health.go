@@ -0,0 +1,181 @@
+package amqpx
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// IHealthChecker report health status
+type IHealthChecker interface {
+	Health(ctx context.Context) *HealthStatus
+}
+
+// HealthStatus is the health status of client or service
+type HealthStatus struct {
+	// all connections are connected and all consumers are alive
+	Healthy bool `json:"healthy"`
+	// healthy and no connection is blocked by broker
+	Ready bool `json:"ready"`
+
+	Connections []ConnectionHealth `json:"connections"`
+	Consumers   []ConsumerHealth   `json:"consumers,omitempty"`
+
+	LastPublishAt *time.Time `json:"lastPublishAt,omitempty"`
+	LastConsumeAt *time.Time `json:"lastConsumeAt,omitempty"`
+}
+
+// ConnectionHealth is the health status of a connection
+type ConnectionHealth struct {
+	// name of connection, consumer or publisher
+	Name          string `json:"name"`
+	Connected     bool   `json:"connected"`
+	Blocked       bool   `json:"blocked"`
+	BlockedReason string `json:"blockedReason,omitempty"`
+	// node endpoint in use, password is redacted
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+// ConsumerHealth is the health status of a consumer
+type ConsumerHealth struct {
+	Consumer string `json:"consumer"`
+	Topic    string `json:"topic"`
+	// the consume loop is still running
+	Alive bool `json:"alive"`
+	// stopped by Stop, a stopped consumer is not alive but it is not unhealthy
+	Stopped       bool       `json:"stopped"`
+	LastConsumeAt *time.Time `json:"lastConsumeAt,omitempty"`
+}
+
+var _ IHealthChecker = (*AMQPClient)(nil)
+
+// report the health status of client connections
+func (c *AMQPClient) Health(ctx context.Context) *HealthStatus {
+	status := &HealthStatus{
+		Healthy:       true,
+		LastPublishAt: unixNanoTime(c.lastPublishAt.Load()),
+	}
+	connections := []*amqpConnection{c.consumeConn}
+	if c.publishConn != c.consumeConn {
+		connections = append(connections, c.publishConn)
+	}
+	for _, eachConnection := range connections {
+		connectionHealth := eachConnection.health()
+		status.Healthy = status.Healthy && connectionHealth.Connected
+		status.Connections = append(status.Connections, connectionHealth)
+	}
+	status.updateReady()
+	return status
+}
+
+// report the health status of client connections and service consumers
+func (s *amqpService) Health(ctx context.Context) *HealthStatus {
+	status := s.client.Health(ctx)
+
+	s.lock.Lock()
+	if s.isShutdown {
+		status.Healthy = false
+	}
+	lastConsumeAt := s.lastConsumeAt.Load()
+	for eachConsumer := range s.consumers {
+		consumerHealth := eachConsumer.health()
+		if consumerHealth.Stopped && !consumerHealth.Alive {
+			// stopped by user and exited, no longer report it
+			delete(s.consumers, eachConsumer)
+			continue
+		}
+		if consumerHealth.LastConsumeAt != nil && consumerHealth.LastConsumeAt.UnixNano() > lastConsumeAt {
+			lastConsumeAt = consumerHealth.LastConsumeAt.UnixNano()
+		}
+		status.Healthy = status.Healthy && (consumerHealth.Alive || consumerHealth.Stopped)
+		status.Consumers = append(status.Consumers, consumerHealth)
+	}
+	s.lock.Unlock()
+
+	sort.Slice(status.Consumers, func(i, j int) bool {
+		return status.Consumers[i].Consumer < status.Consumers[j].Consumer
+	})
+	status.LastConsumeAt = unixNanoTime(lastConsumeAt)
+	status.updateReady()
+	return status
+}
+
+func (s *HealthStatus) updateReady() {
+	s.Ready = s.Healthy
+	for _, eachConnection := range s.Connections {
+		if eachConnection.Blocked {
+			s.Ready = false
+		}
+	}
+}
+
+func (c *amqpConnection) health() ConnectionHealth {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return ConnectionHealth{
+		Name:          c.name,
+		Connected:     c.isConnected,
+		Blocked:       c.isBlocked,
+		BlockedReason: c.blockedReason,
+		Endpoint:      redactEndpoint(c.endpoint),
+	}
+}
+
+func newConsumerHealth(consumer string, topic string, done <-chan struct{}, stopped bool, lastConsumeAt int64) ConsumerHealth {
+	alive := true
+	select {
+	case <-done:
+		alive = false
+	default:
+	}
+	return ConsumerHealth{
+		Consumer:      consumer,
+		Topic:         topic,
+		Alive:         alive,
+		Stopped:       stopped,
+		LastConsumeAt: unixNanoTime(lastConsumeAt),
+	}
+}
+
+func unixNanoTime(unixNano int64) *time.Time {
+	if unixNano <= 0 {
+		return nil
+	}
+	t := time.Unix(0, unixNano)
+	return &t
+}
+
+// create a http.Handler serves health status as json, it responds 200 when healthy, otherwise 503.
+// it can be used as kubernetes liveness probe
+func NewHealthHandler(checker IHealthChecker) http.Handler {
+	return &healthHandler{checker: checker}
+}
+
+// create a http.Handler serves health status as json, it responds 200 when ready, otherwise 503.
+// it can be used as kubernetes readiness probe
+func NewReadinessHandler(checker IHealthChecker) http.Handler {
+	return &healthHandler{checker: checker, readiness: true}
+}
+
+type healthHandler struct {
+	checker   IHealthChecker
+	readiness bool
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := h.checker.Health(r.Context())
+	ok := status.Healthy
+	if h.readiness {
+		ok = status.Ready
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
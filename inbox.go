@@ -0,0 +1,256 @@
+package amqpx
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	_defaultInboxCapacity     = 100000
+	_defaultInboxTTL          = 24 * time.Hour
+	_defaultInboxClaimTimeout = 5 * time.Minute
+	_defaultDedupRequeueDelay = 1 * time.Second
+)
+
+// InboxState is the state of a message key in inbox
+type InboxState int
+
+const (
+	// claimed by this call, the message should be processed
+	InboxState_Claimed InboxState = iota
+	// claimed by another processing which is not completed nor expired
+	InboxState_InProgress
+	// the message is processed and acked
+	InboxState_Completed
+)
+
+// InboxStore record the keys of processing and processed messages, see MemoryInboxStore and SQLInboxStore.
+//
+// a claim expires after the claim timeout, so the message is processed again when
+// the processing is interrupted by a crash or a lost connection
+type InboxStore interface {
+	// claim key before processing
+	Claim(ctx context.Context, key string) (InboxState, error)
+	// mark the claimed key completed after the message acked
+	Complete(ctx context.Context, key string) error
+	// release the claimed key when processing failed, so the message can be processed again
+	Release(ctx context.Context, key string) error
+}
+
+// DedupOptions configure dedup observer
+type DedupOptions struct {
+	// get dedup key of message, default is MessageId. messages with empty key are not deduplicated
+	KeyFunc func(msg *DeliveryMessage) string
+	// invoked when store has error, the message is still processed
+	ErrorHandler func(err error)
+	// hold a message whose key is in progress before requeue, so it is not redelivered in a tight loop.
+	// default is 1s
+	RequeueDelay time.Duration
+}
+
+// DedupOption used to configure dedup observer
+type DedupOption func(o *DedupOptions)
+
+// get dedup key by fn instead of MessageId, such as a business id in headers
+func WithDedupKey(fn func(msg *DeliveryMessage) string) DedupOption {
+	return func(o *DedupOptions) {
+		if fn != nil {
+			o.KeyFunc = fn
+		}
+	}
+}
+
+// set handler invoked when store has error
+func WithDedupErrorHandler(fn func(err error)) DedupOption {
+	return func(o *DedupOptions) {
+		o.ErrorHandler = fn
+	}
+}
+
+// hold a message whose key is in progress for delay before requeue it
+func WithDedupRequeueDelay(delay time.Duration) DedupOption {
+	return func(o *DedupOptions) {
+		if delay > 0 {
+			o.RequeueDelay = delay
+		}
+	}
+}
+
+// wrap observeFn so that a message is processed only once.
+//
+// the key is claimed before observeFn is invoked, and marked completed when the message is acked.
+// a message whose key is completed is acked without invoking observeFn, a message whose key
+// is in progress is held unacked for the requeue delay and then requeued, it is processed again
+// when the claim is released or expired. the held message counts against the prefetch count.
+// the claim is released when observeFn panics or the message is nacked or rejected
+func NewDedupObserver(store InboxStore, observeFn func(msg *DeliveryMessage), opts ...DedupOption) func(msg *DeliveryMessage) {
+	options := &DedupOptions{
+		KeyFunc: func(msg *DeliveryMessage) string {
+			return msg.MessageId()
+		},
+		RequeueDelay: _defaultDedupRequeueDelay,
+	}
+	for _, eachOpt := range opts {
+		eachOpt(options)
+	}
+	notifyError := func(err error) {
+		if options.ErrorHandler != nil {
+			options.ErrorHandler(err)
+		}
+	}
+	return func(msg *DeliveryMessage) {
+		key := options.KeyFunc(msg)
+		if key == "" {
+			observeFn(msg)
+			return
+		}
+		state, err := store.Claim(context.Background(), key)
+		if err != nil {
+			// prefer processing twice to losing message
+			notifyError(fmt.Errorf("cannot claim message %s, %w", key, err))
+			observeFn(msg)
+			return
+		}
+		switch state {
+		case InboxState_Completed:
+			msg.Ack(false)
+			return
+		case InboxState_InProgress:
+			// requeue later without blocking the consumer
+			time.AfterFunc(options.RequeueDelay, func() {
+				msg.Nack(false, true)
+			})
+			return
+		}
+		release := func() {
+			err := store.Release(context.Background(), key)
+			if err != nil {
+				notifyError(fmt.Errorf("cannot release message %s, %w", key, err))
+			}
+		}
+		msg.onSettled(func(ack bool) {
+			if !ack {
+				release()
+				return
+			}
+			err := store.Complete(context.Background(), key)
+			if err != nil {
+				notifyError(fmt.Errorf("cannot complete message %s, %w", key, err))
+			}
+		})
+		defer func() {
+			if p := recover(); p != nil {
+				release()
+				panic(p)
+			}
+		}()
+		observeFn(msg)
+	}
+}
+
+// MemoryInboxStore is a in-memory InboxStore, keys are evicted by LRU when capacity exceeded
+// or when they are expired. it only deduplicates messages within a process
+type MemoryInboxStore struct {
+	capacity     int
+	ttl          time.Duration
+	claimTimeout time.Duration
+
+	// front is the most recently claimed
+	entries *list.List
+	keys    map[string]*list.Element
+	lock    sync.Mutex
+}
+
+type inboxEntry struct {
+	key       string
+	completed bool
+	// the claim expires when not completed, the key expires when completed
+	expiredAt time.Time
+}
+
+var _ InboxStore = (*MemoryInboxStore)(nil)
+
+// create MemoryInboxStore, default capacity is 100000, ttl of completed keys is 24h and claim timeout is 5m
+func NewMemoryInboxStore(capacity int, ttl time.Duration, claimTimeout time.Duration) *MemoryInboxStore {
+	if capacity <= 0 {
+		capacity = _defaultInboxCapacity
+	}
+	if ttl <= 0 {
+		ttl = _defaultInboxTTL
+	}
+	if claimTimeout <= 0 {
+		claimTimeout = _defaultInboxClaimTimeout
+	}
+	return &MemoryInboxStore{
+		capacity:     capacity,
+		ttl:          ttl,
+		claimTimeout: claimTimeout,
+		entries:      list.New(),
+		keys:         make(map[string]*list.Element),
+	}
+}
+
+// #region InboxStore Members
+
+func (s *MemoryInboxStore) Claim(ctx context.Context, key string) (InboxState, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if element, ok := s.keys[key]; ok {
+		entry := element.Value.(*inboxEntry)
+		if now.Before(entry.expiredAt) {
+			s.entries.MoveToFront(element)
+			if entry.completed {
+				return InboxState_Completed, nil
+			}
+			return InboxState_InProgress, nil
+		}
+		s.remove(element)
+	}
+	s.keys[key] = s.entries.PushFront(&inboxEntry{
+		key:       key,
+		expiredAt: now.Add(s.claimTimeout),
+	})
+	for s.entries.Len() > s.capacity {
+		s.remove(s.entries.Back())
+	}
+	return InboxState_Claimed, nil
+}
+
+func (s *MemoryInboxStore) Complete(ctx context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	element, ok := s.keys[key]
+	if !ok {
+		// evicted while processing
+		element = s.entries.PushFront(&inboxEntry{key: key})
+		s.keys[key] = element
+		for s.entries.Len() > s.capacity {
+			s.remove(s.entries.Back())
+		}
+	}
+	entry := element.Value.(*inboxEntry)
+	entry.completed = true
+	entry.expiredAt = time.Now().Add(s.ttl)
+	return nil
+}
+
+func (s *MemoryInboxStore) Release(ctx context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if element, ok := s.keys[key]; ok && !element.Value.(*inboxEntry).completed {
+		s.remove(element)
+	}
+	return nil
+}
+
+// #endregion
+
+// must be called with lock held
+func (s *MemoryInboxStore) remove(element *list.Element) {
+	s.entries.Remove(element)
+	delete(s.keys, element.Value.(*inboxEntry).key)
+}
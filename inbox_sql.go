@@ -0,0 +1,117 @@
+package amqpx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SQLInboxStore is a InboxStore backed by database/sql, it deduplicates messages across processes.
+//
+// the table should be created before use, for example in sqlite:
+//
+//	CREATE TABLE amqp_inbox (
+//		message_key VARCHAR(255) PRIMARY KEY,
+//		completed SMALLINT NOT NULL DEFAULT 0,
+//		created_at BIGINT NOT NULL
+//	);
+//
+// created_at is unix milliseconds when the key is claimed or completed,
+// call Purge periodically to remove old keys
+type SQLInboxStore struct {
+	db           *sql.DB
+	table        string
+	placeholder  SQLPlaceholder
+	claimTimeout time.Duration
+}
+
+var _ InboxStore = (*SQLInboxStore)(nil)
+
+// create SQLInboxStore on table, a claim not completed expires after claimTimeout, default is 5m
+func NewSQLInboxStore(db *sql.DB, table string, placeholder SQLPlaceholder, claimTimeout time.Duration) *SQLInboxStore {
+	if claimTimeout <= 0 {
+		claimTimeout = _defaultInboxClaimTimeout
+	}
+	return &SQLInboxStore{
+		db:           db,
+		table:        table,
+		placeholder:  placeholder,
+		claimTimeout: claimTimeout,
+	}
+}
+
+// #region InboxStore Members
+
+func (s *SQLInboxStore) Claim(ctx context.Context, key string) (InboxState, error) {
+	now := time.Now().UnixMilli()
+	query := bindSQL(fmt.Sprintf("INSERT INTO %s (message_key, completed, created_at) VALUES (?, 0, ?)", s.table), s.placeholder)
+	_, err := s.db.ExecContext(ctx, query, key, now)
+	if err == nil {
+		return InboxState_Claimed, nil
+	}
+	// the error of primary key conflict differs among drivers, so check the existing key
+	completed, claimedAt, getErr := s.get(ctx, key)
+	if errors.Is(getErr, sql.ErrNoRows) {
+		return InboxState_Claimed, err
+	}
+	if getErr != nil {
+		return InboxState_Claimed, err
+	}
+	if completed {
+		return InboxState_Completed, nil
+	}
+	if now-claimedAt < s.claimTimeout.Milliseconds() {
+		return InboxState_InProgress, nil
+	}
+	// the claim is expired, take it over unless others did
+	query = bindSQL(fmt.Sprintf("UPDATE %s SET created_at = ? WHERE message_key = ? AND completed = 0 AND created_at = ?", s.table), s.placeholder)
+	result, err := s.db.ExecContext(ctx, query, now, key, claimedAt)
+	if err != nil {
+		return InboxState_Claimed, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return InboxState_Claimed, err
+	}
+	if affected <= 0 {
+		return InboxState_InProgress, nil
+	}
+	return InboxState_Claimed, nil
+}
+
+func (s *SQLInboxStore) Complete(ctx context.Context, key string) error {
+	query := bindSQL(fmt.Sprintf("UPDATE %s SET completed = 1, created_at = ? WHERE message_key = ?", s.table), s.placeholder)
+	_, err := s.db.ExecContext(ctx, query, time.Now().UnixMilli(), key)
+	return err
+}
+
+func (s *SQLInboxStore) Release(ctx context.Context, key string) error {
+	query := bindSQL(fmt.Sprintf("DELETE FROM %s WHERE message_key = ? AND completed = 0", s.table), s.placeholder)
+	_, err := s.db.ExecContext(ctx, query, key)
+	return err
+}
+
+// #endregion
+
+// remove keys claimed or completed before, returns the number of keys removed
+func (s *SQLInboxStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	query := bindSQL(fmt.Sprintf("DELETE FROM %s WHERE created_at < ?", s.table), s.placeholder)
+	result, err := s.db.ExecContext(ctx, query, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLInboxStore) get(ctx context.Context, key string) (bool, int64, error) {
+	query := bindSQL(fmt.Sprintf("SELECT completed, created_at FROM %s WHERE message_key = ?", s.table), s.placeholder)
+	var completed int
+	var createdAt int64
+	err := s.db.QueryRowContext(ctx, query, key).Scan(&completed, &createdAt)
+	if err != nil {
+		return false, 0, err
+	}
+	return completed != 0, createdAt, nil
+}
@@ -0,0 +1,74 @@
+package amqpx
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const _testInboxSchema = `CREATE TABLE amqp_inbox (
+	message_key VARCHAR(255) PRIMARY KEY,
+	completed SMALLINT NOT NULL DEFAULT 0,
+	created_at BIGINT NOT NULL
+)`
+
+func TestSQLInboxStore_Claim(t *testing.T) {
+	db := openTestDB(t, _testInboxSchema)
+	store := NewSQLInboxStore(db, "amqp_inbox", SQLPlaceholder_Question, time.Minute)
+	ctx := context.Background()
+
+	assertInboxState(t, store, "key1", InboxState_Claimed)
+	assertInboxState(t, store, "key1", InboxState_InProgress)
+
+	err := store.Release(ctx, "key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInboxState(t, store, "key1", InboxState_Claimed)
+
+	err = store.Complete(ctx, "key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInboxState(t, store, "key1", InboxState_Completed)
+	// a completed key is not released
+	err = store.Release(ctx, "key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInboxState(t, store, "key1", InboxState_Completed)
+}
+
+func TestSQLInboxStore_ClaimExpired(t *testing.T) {
+	db := openTestDB(t, _testInboxSchema)
+	store := NewSQLInboxStore(db, "amqp_inbox", SQLPlaceholder_Question, time.Minute)
+	ctx := context.Background()
+
+	assertInboxState(t, store, "key1", InboxState_Claimed)
+	_, err := db.Exec("UPDATE amqp_inbox SET created_at = ? WHERE message_key = ?", time.Now().Add(-2*time.Minute).UnixMilli(), "key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInboxState(t, store, "key1", InboxState_Claimed)
+	assertInboxState(t, store, "key1", InboxState_InProgress)
+
+	removed, err := store.Purge(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("expect 1 key purged, got %d", removed)
+	}
+	assertInboxState(t, store, "key1", InboxState_Claimed)
+}
+
+func assertInboxState(t *testing.T, store InboxStore, key string, expected InboxState) {
+	t.Helper()
+	state, err := store.Claim(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != expected {
+		t.Fatalf("expect state %d of %s, got %d", expected, key, state)
+	}
+}
@@ -0,0 +1,166 @@
+package amqpx
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeAcknowledger record how the deliveries are settled
+type fakeAcknowledger struct {
+	acked    []uint64
+	nacked   []uint64
+	requeued []uint64
+	lock     sync.Mutex
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.nacked = append(a.nacked, tag)
+	if requeue {
+		a.requeued = append(a.requeued, tag)
+	}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func (a *fakeAcknowledger) counts() (int, int, int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.acked), len(a.nacked), len(a.requeued)
+}
+
+func (a *fakeAcknowledger) delivery(tag uint64, messageId string) *DeliveryMessage {
+	return newDeliveryMessage(&amqp.Delivery{
+		Acknowledger: a,
+		DeliveryTag:  tag,
+		MessageId:    messageId,
+	})
+}
+
+func TestMemoryInboxStore_Evict(t *testing.T) {
+	store := NewMemoryInboxStore(2, time.Minute, time.Minute)
+
+	assertInboxState(t, store, "key1", InboxState_Claimed)
+	assertInboxState(t, store, "key2", InboxState_Claimed)
+	// key1 becomes the most recently claimed
+	assertInboxState(t, store, "key1", InboxState_InProgress)
+	// key2 is evicted
+	assertInboxState(t, store, "key3", InboxState_Claimed)
+	assertInboxState(t, store, "key1", InboxState_InProgress)
+	assertInboxState(t, store, "key2", InboxState_Claimed)
+	// key3 is evicted
+	assertInboxState(t, store, "key3", InboxState_Claimed)
+}
+
+func TestMemoryInboxStore_Expire(t *testing.T) {
+	store := NewMemoryInboxStore(10, 50*time.Millisecond, 50*time.Millisecond)
+
+	assertInboxState(t, store, "key1", InboxState_Claimed)
+	assertInboxState(t, store, "key1", InboxState_InProgress)
+	// the claim expires
+	time.Sleep(60 * time.Millisecond)
+	assertInboxState(t, store, "key1", InboxState_Claimed)
+
+	err := store.Complete(context.Background(), "key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertInboxState(t, store, "key1", InboxState_Completed)
+	// the completed key expires
+	time.Sleep(60 * time.Millisecond)
+	assertInboxState(t, store, "key1", InboxState_Claimed)
+}
+
+func TestDedupObserver_AckDuplicate(t *testing.T) {
+	store := NewMemoryInboxStore(10, time.Minute, time.Minute)
+	acknowledger := &fakeAcknowledger{}
+	observed := 0
+	observer := NewDedupObserver(store, func(msg *DeliveryMessage) {
+		observed++
+		msg.Ack(false)
+	})
+
+	observer(acknowledger.delivery(1, "message1"))
+	observer(acknowledger.delivery(2, "message1"))
+	if observed != 1 {
+		t.Fatalf("expect the duplicate not observed, got %d", observed)
+	}
+	acked, nacked, _ := acknowledger.counts()
+	if acked != 2 || nacked != 0 {
+		t.Fatalf("expect both deliveries acked, got %d acked and %d nacked", acked, nacked)
+	}
+}
+
+func TestDedupObserver_RequeueInProgressLater(t *testing.T) {
+	store := NewMemoryInboxStore(10, time.Minute, time.Minute)
+	acknowledger := &fakeAcknowledger{}
+	observer := NewDedupObserver(store, func(msg *DeliveryMessage) {
+		// still processing
+	}, WithDedupRequeueDelay(100*time.Millisecond))
+
+	observer(acknowledger.delivery(1, "message1"))
+	observer(acknowledger.delivery(2, "message1"))
+	_, _, requeued := acknowledger.counts()
+	if requeued != 0 {
+		t.Fatal("expect the duplicate in progress held before requeue")
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		_, _, requeued := acknowledger.counts()
+		return requeued == 1
+	})
+}
+
+func TestDedupObserver_ReleaseOnNack(t *testing.T) {
+	store := NewMemoryInboxStore(10, time.Minute, time.Minute)
+	acknowledger := &fakeAcknowledger{}
+	observed := 0
+	observer := NewDedupObserver(store, func(msg *DeliveryMessage) {
+		observed++
+		if observed == 1 {
+			msg.Nack(false, true)
+			return
+		}
+		msg.Ack(false)
+	})
+
+	observer(acknowledger.delivery(1, "message1"))
+	// processed again after released
+	observer(acknowledger.delivery(2, "message1"))
+	if observed != 2 {
+		t.Fatalf("expect the message observed again after nacked, got %d", observed)
+	}
+	assertInboxState(t, store, "message1", InboxState_Completed)
+}
+
+func TestDedupObserver_ReleaseOnPanic(t *testing.T) {
+	store := NewMemoryInboxStore(10, time.Minute, time.Minute)
+	acknowledger := &fakeAcknowledger{}
+	observer := NewDedupObserver(store, func(msg *DeliveryMessage) {
+		panic("failed")
+	})
+
+	func() {
+		defer func() {
+			if p := recover(); p == nil {
+				t.Fatal("expect the panic propagated")
+			}
+		}()
+		observer(acknowledger.delivery(1, "message1"))
+	}()
+	assertInboxState(t, store, "message1", InboxState_Claimed)
+}
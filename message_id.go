@@ -0,0 +1,34 @@
+package amqpx
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// generate a UUID version 7, it is time-ordered so it is friendly to database index.
+// see RFC 9562
+func NewUUIDv7() string {
+	var uuid [16]byte
+	// the random bits, crypto/rand never fails on supported platforms
+	rand.Read(uuid[6:])
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	// 48 bits unix milliseconds
+	copy(uuid[:6], ms[2:])
+	uuid[6] = (uuid[6] & 0x0f) | 0x70
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], uuid[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], uuid[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], uuid[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], uuid[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], uuid[10:])
+	return string(buf[:])
+}
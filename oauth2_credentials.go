@@ -0,0 +1,126 @@
+package amqpx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuth2CredentialsProvider get access token from OAuth2 server by client credentials grant,
+// the token is used as password, it is cached and refreshed before it expires.
+//
+// it works with the rabbitmq_auth_backend_oauth2 plugin
+type OAuth2CredentialsProvider struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// username sent with token, rabbitmq_auth_backend_oauth2 ignores it
+	Username string
+	// refresh token before it expires, default is 1 minute
+	RefreshBefore time.Duration
+	// http client used to request token, default is http.DefaultClient
+	HTTPClient *http.Client
+
+	credentials *Credentials
+	lock        sync.Mutex
+}
+
+var _ CredentialsProvider = (*OAuth2CredentialsProvider)(nil)
+
+// new a OAuth2CredentialsProvider with client credentials grant
+func NewOAuth2CredentialsProvider(tokenURL string, clientID string, clientSecret string, scopes ...string) *OAuth2CredentialsProvider {
+	return &OAuth2CredentialsProvider{
+		TokenURL:      tokenURL,
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		Scopes:        scopes,
+		RefreshBefore: _defaultRefreshBefore,
+	}
+}
+
+// #region CredentialsProvider Members
+
+func (p *OAuth2CredentialsProvider) Credentials(ctx context.Context) (*Credentials, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.credentials != nil && !p.needRefresh(p.credentials) {
+		return p.credentials, nil
+	}
+	credentials, err := p.requestToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.credentials = credentials
+	return credentials, nil
+}
+
+// #endregion
+
+func (p *OAuth2CredentialsProvider) needRefresh(credentials *Credentials) bool {
+	if credentials.RefreshAt.IsZero() {
+		return false
+	}
+	return !time.Now().Before(credentials.RefreshAt)
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (p *OAuth2CredentialsProvider) requestToken(ctx context.Context) (*Credentials, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(p.Scopes) > 0 {
+		form.Set("scope", strings.Join(p.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	requestAt := time.Now()
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request oauth2 token error, status: %s", res.Status)
+	}
+	token := &oauth2TokenResponse{}
+	err = json.NewDecoder(res.Body).Decode(token)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode oauth2 token response, %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("oauth2 token response has no access_token")
+	}
+	credentials := &Credentials{
+		Username: p.Username,
+		Password: token.AccessToken,
+	}
+	if token.ExpiresIn > 0 {
+		refreshBefore := p.RefreshBefore
+		if refreshBefore <= 0 {
+			refreshBefore = _defaultRefreshBefore
+		}
+		credentials.ExpiresAt = requestAt.Add(time.Duration(token.ExpiresIn) * time.Second)
+		credentials.RefreshAt = refreshTime(requestAt, credentials.ExpiresAt, refreshBefore)
+	}
+	return credentials, nil
+}
@@ -0,0 +1,256 @@
+package amqpx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	_defaultOutboxRelayInterval = 1 * time.Second
+	_defaultOutboxBatchSize     = 100
+)
+
+// ErrReturned returned when a mandatory outbox message is returned by broker because it is unroutable,
+// the message is still pending and published again by the next relay
+var ErrReturned = errors.New("message is returned by broker")
+
+// OutboxMessage a message saved in outbox, it is published by relay after the transaction committed
+type OutboxMessage struct {
+	Id int64
+	// message id published, it is kept across relay retries so consumers can deduplicate
+	MessageId    string
+	Exchange     string
+	Key          string
+	Mandatory    bool
+	DeliveryMode uint8
+	Payload      []byte
+	CreatedAt    time.Time
+	// number of failed publishing
+	Attempts int
+}
+
+// OutboxTx is the transaction the outbox message is written in, *sql.Tx satisfies it
+type OutboxTx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// OutboxStore persist outbox messages, see SQLOutboxStore
+type OutboxStore interface {
+	// save message in tx, it becomes pending after tx committed
+	Insert(ctx context.Context, tx OutboxTx, msg *OutboxMessage) error
+	// get at most limit pending messages in insertion order
+	FetchPending(ctx context.Context, limit int) ([]*OutboxMessage, error)
+	// mark message sent, it will not be fetched again
+	MarkSent(ctx context.Context, id int64) error
+	// record a failed publishing, the message is still pending
+	MarkFailed(ctx context.Context, id int64, err error) error
+}
+
+// OutboxOption used to configure Outbox
+type OutboxOption func(o *Outbox)
+
+// set the interval the relay polls pending messages, default is 1s
+func WithOutboxRelayInterval(interval time.Duration) OutboxOption {
+	return func(o *Outbox) {
+		if interval > 0 {
+			o.relayInterval = interval
+		}
+	}
+}
+
+// set the max number of messages published in each poll, default is 100
+func WithOutboxBatchSize(batchSize int) OutboxOption {
+	return func(o *Outbox) {
+		if batchSize > 0 {
+			o.batchSize = batchSize
+		}
+	}
+}
+
+// set handler invoked when relay has error, such as publish failed
+func WithOutboxErrorHandler(fn func(err error)) OutboxOption {
+	return func(o *Outbox) {
+		o.errorHandler = fn
+	}
+}
+
+// Outbox implements transactional outbox.
+//
+// messages are written to store in the business transaction by PublishInTx,
+// then the relay publishes them with publisher confirms and marks them sent.
+// the delivery is at-least-once, a message may be published again when the relay
+// crashes between publishing and marking, so consumers should be idempotent.
+// only one relay should run against the same store to keep the order
+type Outbox struct {
+	store     OutboxStore
+	publisher IAMQPPublisher
+
+	relayInterval time.Duration
+	batchSize     int
+	errorHandler  func(err error)
+
+	cancelFunc context.CancelFunc
+	doneCh     chan struct{}
+	lock       sync.Mutex
+}
+
+// create Outbox, call Start to run the relay
+func NewOutbox(store OutboxStore, publisher IAMQPPublisher, opts ...OutboxOption) *Outbox {
+	o := &Outbox{
+		store:         store,
+		publisher:     publisher,
+		relayInterval: _defaultOutboxRelayInterval,
+		batchSize:     _defaultOutboxBatchSize,
+	}
+	for _, eachOpt := range opts {
+		eachOpt(o)
+	}
+	return o
+}
+
+// write the message to outbox in tx, it is published after tx committed.
+// WithConfirm and WithImmediate are ignored, the relay always publishes with confirms
+func (o *Outbox) PublishInTx(tx OutboxTx, v interface{}, opts ...PublishOption) error {
+	publishContext := NewDefaultPublishContext()
+	for _, eachOpt := range opts {
+		eachOpt(publishContext)
+	}
+	if publishContext.cancelFunc != nil {
+		defer publishContext.cancelFunc()
+	}
+	if publishContext.key == "" {
+		return fmt.Errorf("key is empty")
+	}
+	// generate message id now, so that the message is published with the same id when relay retries
+	publishContext.stamp(NewUUIDv7, "")
+	if publishContext.result != nil {
+		publishContext.result.MessageId = publishContext.messageId
+	}
+	data, err := publishContext.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot serialize object,v: %+v", v)
+	}
+	return o.store.Insert(publishContext.ctx, tx, &OutboxMessage{
+		MessageId:    publishContext.messageId,
+		Exchange:     publishContext.exchange,
+		Key:          publishContext.key,
+		Mandatory:    publishContext.mandatory,
+		DeliveryMode: publishContext.deliveryMode,
+		Payload:      data,
+		CreatedAt:    time.Now(),
+	})
+}
+
+// run the relay in background
+func (o *Outbox) Start() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.cancelFunc != nil {
+		return
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	o.cancelFunc = cancelFunc
+	o.doneCh = make(chan struct{})
+	go o.relay(ctx, o.doneCh)
+}
+
+// stop the relay and wait for it exited
+func (o *Outbox) Stop() {
+	o.lock.Lock()
+	cancelFunc := o.cancelFunc
+	doneCh := o.doneCh
+	o.cancelFunc = nil
+	o.lock.Unlock()
+	if cancelFunc == nil {
+		return
+	}
+	cancelFunc()
+	<-doneCh
+}
+
+// publish pending messages once, returns the number of messages sent.
+// it stops at the first failed message to keep the order
+func (o *Outbox) Relay(ctx context.Context) (int, error) {
+	msgs, err := o.store.FetchPending(ctx, o.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for i, eachMsg := range msgs {
+		err = o.publish(ctx, eachMsg)
+		if err != nil {
+			markErr := o.store.MarkFailed(ctx, eachMsg.Id, err)
+			if markErr != nil {
+				o.notifyError(markErr)
+			}
+			return i, fmt.Errorf("cannot publish outbox message %d, %w", eachMsg.Id, err)
+		}
+		err = o.store.MarkSent(ctx, eachMsg.Id)
+		if err != nil {
+			return i, fmt.Errorf("cannot mark outbox message %d sent, %w", eachMsg.Id, err)
+		}
+	}
+	return len(msgs), nil
+}
+
+func (o *Outbox) relay(ctx context.Context, doneCh chan struct{}) {
+	defer close(doneCh)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		sent, err := o.Relay(ctx)
+		if err != nil && ctx.Err() == nil {
+			o.notifyError(err)
+		}
+		// continue immediately when there may be more pending messages
+		if err == nil && sent >= o.batchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(o.relayInterval)
+		}
+	}
+}
+
+func (o *Outbox) publish(ctx context.Context, msg *OutboxMessage) error {
+	publishCtx, cancelFunc := context.WithTimeout(ctx, _defaultTimeout)
+	opts := []PublishOption{
+		WithContext(publishCtx, cancelFunc),
+		WithExchange(msg.Exchange),
+		WithKey(msg.Key),
+		WithMandatory(msg.Mandatory),
+		WithConfirm(true),
+		WithMessageId(msg.MessageId),
+		func(c *PublishContext) {
+			c.deliveryMode = msg.DeliveryMode
+		},
+	}
+	result, err := o.publisher.PublishWithResult(msg.Payload, opts...)
+	if err != nil {
+		return err
+	}
+	// a returned message is acked by broker, but it is not delivered to any queue
+	if result.Returned {
+		return fmt.Errorf("%w, reply code %d, reason %s", ErrReturned, result.Return.ReplyCode, result.Return.ReplyText)
+	}
+	return nil
+}
+
+func (o *Outbox) notifyError(err error) {
+	if o.errorHandler == nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Printf("Outbox.notifyError panic when notify error handler, panic: %v", p)
+		}
+	}()
+	o.errorHandler(err)
+}
@@ -0,0 +1,139 @@
+package amqpx
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLPlaceholder define the bind parameter style of sql driver
+type SQLPlaceholder int
+
+const (
+	// ?, used by sqlite and mysql
+	SQLPlaceholder_Question SQLPlaceholder = iota
+	// $1, used by postgres
+	SQLPlaceholder_Dollar
+)
+
+// SQLOutboxStore is a OutboxStore backed by database/sql.
+//
+// the table should be created before use, for example in sqlite:
+//
+//	CREATE TABLE amqp_outbox (
+//		id INTEGER PRIMARY KEY AUTOINCREMENT,
+//		message_id VARCHAR(64) NOT NULL,
+//		exchange VARCHAR(255) NOT NULL,
+//		routing_key VARCHAR(255) NOT NULL,
+//		mandatory BOOLEAN NOT NULL,
+//		delivery_mode SMALLINT NOT NULL,
+//		payload BLOB,
+//		created_at BIGINT NOT NULL,
+//		sent_at BIGINT,
+//		attempts INTEGER NOT NULL DEFAULT 0,
+//		last_error TEXT
+//	);
+//	CREATE INDEX idx_amqp_outbox_pending ON amqp_outbox (sent_at, id);
+//
+// created_at and sent_at are unix milliseconds
+type SQLOutboxStore struct {
+	db          *sql.DB
+	table       string
+	placeholder SQLPlaceholder
+}
+
+var _ OutboxStore = (*SQLOutboxStore)(nil)
+
+// create SQLOutboxStore on table
+func NewSQLOutboxStore(db *sql.DB, table string, placeholder SQLPlaceholder) *SQLOutboxStore {
+	return &SQLOutboxStore{
+		db:          db,
+		table:       table,
+		placeholder: placeholder,
+	}
+}
+
+// #region OutboxStore Members
+
+func (s *SQLOutboxStore) Insert(ctx context.Context, tx OutboxTx, msg *OutboxMessage) error {
+	query := s.bind(fmt.Sprintf("INSERT INTO %s (message_id, exchange, routing_key, mandatory, delivery_mode, payload, created_at, attempts) VALUES (?, ?, ?, ?, ?, ?, ?, 0)", s.table))
+	_, err := tx.ExecContext(ctx, query,
+		msg.MessageId,
+		msg.Exchange,
+		msg.Key,
+		msg.Mandatory,
+		int(msg.DeliveryMode),
+		msg.Payload,
+		msg.CreatedAt.UnixMilli())
+	return err
+}
+
+func (s *SQLOutboxStore) FetchPending(ctx context.Context, limit int) ([]*OutboxMessage, error) {
+	query := s.bind(fmt.Sprintf("SELECT id, message_id, exchange, routing_key, mandatory, delivery_mode, payload, created_at, attempts FROM %s WHERE sent_at IS NULL ORDER BY id LIMIT ?", s.table))
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []*OutboxMessage
+	for rows.Next() {
+		msg := &OutboxMessage{}
+		var deliveryMode int
+		var createdAt int64
+		err = rows.Scan(&msg.Id,
+			&msg.MessageId,
+			&msg.Exchange,
+			&msg.Key,
+			&msg.Mandatory,
+			&deliveryMode,
+			&msg.Payload,
+			&createdAt,
+			&msg.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		msg.DeliveryMode = uint8(deliveryMode)
+		msg.CreatedAt = time.UnixMilli(createdAt)
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+func (s *SQLOutboxStore) MarkSent(ctx context.Context, id int64) error {
+	query := s.bind(fmt.Sprintf("UPDATE %s SET sent_at = ? WHERE id = ?", s.table))
+	_, err := s.db.ExecContext(ctx, query, time.Now().UnixMilli(), id)
+	return err
+}
+
+func (s *SQLOutboxStore) MarkFailed(ctx context.Context, id int64, err error) error {
+	query := s.bind(fmt.Sprintf("UPDATE %s SET attempts = attempts + 1, last_error = ? WHERE id = ?", s.table))
+	_, execErr := s.db.ExecContext(ctx, query, err.Error(), id)
+	return execErr
+}
+
+// #endregion
+
+func (s *SQLOutboxStore) bind(query string) string {
+	return bindSQL(query, s.placeholder)
+}
+
+// convert ? to the placeholder style of driver
+func bindSQL(query string, placeholder SQLPlaceholder) string {
+	if placeholder != SQLPlaceholder_Dollar {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, eachRune := range query {
+		if eachRune != '?' {
+			b.WriteRune(eachRune)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
@@ -0,0 +1,278 @@
+package amqpx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const _testOutboxSchema = `CREATE TABLE amqp_outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	message_id VARCHAR(64) NOT NULL,
+	exchange VARCHAR(255) NOT NULL,
+	routing_key VARCHAR(255) NOT NULL,
+	mandatory BOOLEAN NOT NULL,
+	delivery_mode SMALLINT NOT NULL,
+	payload BLOB,
+	created_at BIGINT NOT NULL,
+	sent_at BIGINT,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT
+)`
+
+func openTestDB(t *testing.T, schema string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// keep the in-memory database alive
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	_, err = db.Exec(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// fakePublisher record the publishings, it fails when failKey is matched,
+// and the publishing is returned when returnKey is matched
+type fakePublisher struct {
+	failKey   string
+	returnKey string
+	published []*PublishContext
+	lock      sync.Mutex
+}
+
+var _ IAMQPPublisher = (*fakePublisher)(nil)
+
+func (p *fakePublisher) Publish(v interface{}, opts ...PublishOption) error {
+	_, err := p.PublishWithResult(v, opts...)
+	return err
+}
+
+func (p *fakePublisher) PublishWithResult(v interface{}, opts ...PublishOption) (*PublishResult, error) {
+	publishContext := &PublishContext{}
+	for _, eachOpt := range opts {
+		eachOpt(publishContext)
+	}
+	if publishContext.cancelFunc != nil {
+		publishContext.cancelFunc()
+	}
+	if publishContext.key == p.failKey {
+		return nil, ErrNacked
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.published = append(p.published, publishContext)
+	result := &PublishResult{MessageId: publishContext.messageId, Confirmed: publishContext.confirm}
+	if publishContext.key == p.returnKey {
+		result.Returned = true
+		result.Return = &amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE", RoutingKey: publishContext.key}
+	}
+	return result, nil
+}
+
+func (p *fakePublisher) SendToQueue(ctx context.Context, queue string, v interface{}, opts ...PublishOption) error {
+	return p.Publish(v, append(opts, WithKey(queue))...)
+}
+
+func insertOutboxMessages(t *testing.T, db *sql.DB, outbox *Outbox, keys ...string) {
+	t.Helper()
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, eachKey := range keys {
+		err = outbox.PublishInTx(tx, map[string]string{"key": eachKey}, WithKey(eachKey))
+		if err != nil {
+			tx.Rollback()
+			t.Fatal(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLOutboxStore_InsertRollback(t *testing.T) {
+	db := openTestDB(t, _testOutboxSchema)
+	store := NewSQLOutboxStore(db, "amqp_outbox", SQLPlaceholder_Question)
+	outbox := NewOutbox(store, &fakePublisher{})
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = outbox.PublishInTx(tx, "rolled back", WithKey("key1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := store.FetchPending(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("expect no pending message after rollback, got %d", len(msgs))
+	}
+
+	insertOutboxMessages(t, db, outbox, "key2")
+	msgs, err = store.FetchPending(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Key != "key2" || msgs[0].MessageId == "" {
+		t.Fatalf("expect the committed message, got %+v", msgs)
+	}
+}
+
+func TestSQLOutboxStore_FetchPendingOrder(t *testing.T) {
+	db := openTestDB(t, _testOutboxSchema)
+	store := NewSQLOutboxStore(db, "amqp_outbox", SQLPlaceholder_Question)
+	outbox := NewOutbox(store, &fakePublisher{})
+	insertOutboxMessages(t, db, outbox, "key1", "key2", "key3")
+
+	msgs, err := store.FetchPending(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Key != "key1" || msgs[1].Key != "key2" {
+		t.Fatalf("expect key1 and key2 in order, got %+v", msgs)
+	}
+	if msgs[0].Id >= msgs[1].Id {
+		t.Fatalf("expect ids in insertion order, got %d and %d", msgs[0].Id, msgs[1].Id)
+	}
+}
+
+func TestSQLOutboxStore_MarkSentAndMarkFailed(t *testing.T) {
+	db := openTestDB(t, _testOutboxSchema)
+	store := NewSQLOutboxStore(db, "amqp_outbox", SQLPlaceholder_Question)
+	outbox := NewOutbox(store, &fakePublisher{})
+	insertOutboxMessages(t, db, outbox, "key1", "key2")
+	ctx := context.Background()
+
+	msgs, err := store.FetchPending(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.MarkSent(ctx, msgs[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.MarkFailed(ctx, msgs[1].Id, errors.New("nacked"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.MarkFailed(ctx, msgs[1].Id, errors.New("timeout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err = store.FetchPending(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Key != "key2" || msgs[0].Attempts != 2 {
+		t.Fatalf("expect key2 pending with 2 attempts, got %+v", msgs)
+	}
+	var lastError string
+	err = db.QueryRow("SELECT last_error FROM amqp_outbox WHERE id = ?", msgs[0].Id).Scan(&lastError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastError != "timeout" {
+		t.Fatalf("expect last error timeout, got %s", lastError)
+	}
+}
+
+func TestOutbox_Relay(t *testing.T) {
+	db := openTestDB(t, _testOutboxSchema)
+	store := NewSQLOutboxStore(db, "amqp_outbox", SQLPlaceholder_Question)
+	publisher := &fakePublisher{failKey: "key3"}
+	outbox := NewOutbox(store, publisher)
+	insertOutboxMessages(t, db, outbox, "key1", "key2", "key3", "key4")
+	ctx := context.Background()
+
+	sent, err := outbox.Relay(ctx)
+	if err == nil {
+		t.Fatal("expect error when publishing key3")
+	}
+	if sent != 2 {
+		t.Fatalf("expect 2 messages sent before the failed one, got %d", sent)
+	}
+	if len(publisher.published) != 2 || publisher.published[0].key != "key1" || publisher.published[1].key != "key2" {
+		t.Fatalf("expect key1 and key2 published in order, got %d", len(publisher.published))
+	}
+	for _, eachPublished := range publisher.published {
+		if !eachPublished.confirm || eachPublished.messageId == "" {
+			t.Fatalf("expect publishing with confirm and message id, got %+v", eachPublished)
+		}
+	}
+
+	msgs, err := store.FetchPending(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Key != "key3" || msgs[0].Attempts != 1 || msgs[1].Key != "key4" {
+		t.Fatalf("expect key3 and key4 pending, got %+v", msgs)
+	}
+
+	// relay again with the same message id after the failure is fixed
+	messageId := msgs[0].MessageId
+	publisher.failKey = ""
+	sent, err = outbox.Relay(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 2 {
+		t.Fatalf("expect 2 messages sent, got %d", sent)
+	}
+	if publisher.published[2].messageId != messageId {
+		t.Fatalf("expect message id %s kept across retries, got %s", messageId, publisher.published[2].messageId)
+	}
+	msgs, err = store.FetchPending(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("expect no pending message, got %d", len(msgs))
+	}
+}
+
+func TestOutbox_RelayReturned(t *testing.T) {
+	db := openTestDB(t, _testOutboxSchema)
+	store := NewSQLOutboxStore(db, "amqp_outbox", SQLPlaceholder_Question)
+	publisher := &fakePublisher{returnKey: "key2"}
+	outbox := NewOutbox(store, publisher)
+	insertOutboxMessages(t, db, outbox, "key1", "key2")
+	ctx := context.Background()
+
+	sent, err := outbox.Relay(ctx)
+	if !errors.Is(err, ErrReturned) {
+		t.Fatalf("expect ErrReturned when key2 is unroutable, got %v", err)
+	}
+	if sent != 1 {
+		t.Fatalf("expect 1 message sent before the returned one, got %d", sent)
+	}
+	// the returned message is not marked sent
+	msgs, err := store.FetchPending(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Key != "key2" || msgs[0].Attempts != 1 {
+		t.Fatalf("expect key2 pending with 1 attempt, got %+v", msgs)
+	}
+}
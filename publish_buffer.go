@@ -0,0 +1,470 @@
+package amqpx
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	_defaultPublishBufferCapacity = 1000
+)
+
+// OverflowPolicy define how to buffer a message when the publish buffer is full
+type OverflowPolicy int
+
+const (
+	// wait until there is space or the publish context is done
+	OverflowPolicy_Block OverflowPolicy = iota
+	// drop the oldest buffered message
+	OverflowPolicy_DropOldest
+	// return ErrPublishBufferFull immediately
+	OverflowPolicy_Error
+)
+
+// ErrPublishBufferFull returned when the publish buffer is full and the overflow policy is OverflowPolicy_Error
+var ErrPublishBufferFull = errors.New("publish buffer is full")
+
+// PublishBufferOptions configure the buffer holding messages while the publishing connection is lost
+type PublishBufferOptions struct {
+	// max number of messages held in memory, default is 1000
+	Capacity int
+	// how to buffer a message when memory is full, it is ignored when SpillFile is set
+	OverflowPolicy OverflowPolicy
+	// append messages to this local file when memory is full, so the buffer is unbounded.
+	// the file is truncated only after all messages are replayed, so the messages moved from file
+	// to memory are kept on crash. messages left in the file by last process are replayed too,
+	// so they may be published twice.
+	// header values are saved as json, so numbers become float64 after spilled
+	SpillFile string
+	// invoked when a buffered message is dropped or cannot be replayed
+	ErrorHandler func(err error)
+}
+
+// a message waiting to be published
+type bufferedPublishing struct {
+	Exchange   string          `json:"exchange"`
+	Key        string          `json:"key"`
+	Mandatory  bool            `json:"mandatory"`
+	Immediate  bool            `json:"immediate"`
+	Confirm    bool            `json:"confirm"`
+	Publishing amqp.Publishing `json:"publishing"`
+}
+
+// publishBuffer hold messages while the publishing connection is lost,
+// and replay them in order once reconnected.
+//
+// the oldest messages are held in memory, the newer ones are spilled to file when memory is full
+type publishBuffer struct {
+	client  *AMQPClient
+	options *PublishBufferOptions
+
+	messages *list.List
+	spill    *spillFile
+	// closed when a message is removed, so blocked publishers can retry
+	spaceCh chan struct{}
+	// closed when the buffer becomes empty
+	emptyCh chan struct{}
+	// replay goroutine is running
+	replaying bool
+	closed    bool
+	// signal replay goroutine the connection is established
+	connectedCh chan struct{}
+	// protect fields above
+	lock sync.Mutex
+}
+
+func newPublishBuffer(client *AMQPClient, options *PublishBufferOptions) (*publishBuffer, error) {
+	if options.Capacity <= 0 {
+		options.Capacity = _defaultPublishBufferCapacity
+	}
+	b := &publishBuffer{
+		client:      client,
+		options:     options,
+		messages:    list.New(),
+		spaceCh:     make(chan struct{}),
+		emptyCh:     make(chan struct{}),
+		connectedCh: make(chan struct{}, 1),
+	}
+	if options.SpillFile != "" {
+		spill, err := openSpillFile(options.SpillFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open spill file %s, %w", options.SpillFile, err)
+		}
+		b.spill = spill
+		errs := b.refill()
+		for _, eachErr := range errs {
+			b.notifyError(eachErr)
+		}
+	}
+	if !b.pending() {
+		b.markEmpty()
+	}
+	client.OnConnected(func(connection string) {
+		select {
+		case b.connectedCh <- struct{}{}:
+		default:
+		}
+		b.kick()
+	})
+	b.kick()
+	return b, nil
+}
+
+// is there any message waiting to be published
+func (b *publishBuffer) hasPending() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.pending()
+}
+
+// add message to the tail, it blocks according to the overflow policy
+func (b *publishBuffer) add(ctx context.Context, msg *bufferedPublishing) error {
+	for {
+		b.lock.Lock()
+		if b.closed {
+			b.lock.Unlock()
+			return ErrShutdown
+		}
+		if b.spill != nil && (b.spill.count > 0 || b.messages.Len() >= b.options.Capacity) {
+			err := b.spill.append(msg)
+			if err == nil {
+				b.markPending()
+			}
+			b.lock.Unlock()
+			if err == nil {
+				b.kick()
+			}
+			return err
+		}
+		if b.messages.Len() < b.options.Capacity {
+			b.messages.PushBack(msg)
+			b.markPending()
+			b.lock.Unlock()
+			b.kick()
+			return nil
+		}
+		switch b.options.OverflowPolicy {
+		case OverflowPolicy_DropOldest:
+			dropped := b.messages.Remove(b.messages.Front()).(*bufferedPublishing)
+			b.messages.PushBack(msg)
+			b.lock.Unlock()
+			b.notifyError(fmt.Errorf("%w, drop the oldest message %s", ErrPublishBufferFull, dropped.Publishing.MessageId))
+			b.kick()
+			return nil
+		case OverflowPolicy_Error:
+			b.lock.Unlock()
+			return ErrPublishBufferFull
+		}
+		spaceCh := b.spaceCh
+		b.lock.Unlock()
+		select {
+		case <-spaceCh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// wait until all messages are replayed or ctx is done
+func (b *publishBuffer) waitFlushed(ctx context.Context) error {
+	b.lock.Lock()
+	emptyCh := b.emptyCh
+	b.lock.Unlock()
+	select {
+	case <-emptyCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop replaying, the messages in memory are dropped, the spilled messages are kept in file
+func (b *publishBuffer) close() error {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.spaceCh)
+	dropped := b.messages.Len()
+	var err error
+	if b.spill != nil {
+		err = b.spill.close()
+	}
+	b.lock.Unlock()
+	if dropped > 0 {
+		b.notifyError(fmt.Errorf("%d buffered messages are dropped when shutdown", dropped))
+	}
+	return err
+}
+
+// start replay goroutine if it is not running
+func (b *publishBuffer) kick() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.replaying || b.closed || !b.pending() {
+		return
+	}
+	b.replaying = true
+	go b.replay()
+}
+
+// publish messages in order until the buffer is empty or closed.
+// when the connection is lost, it waits for reconnected or retries with backoff
+func (b *publishBuffer) replay() {
+	interval := b.client.reconnectInterval
+	for {
+		b.lock.Lock()
+		var errs []error
+		if b.messages.Len() <= 0 {
+			errs = b.refill()
+		}
+		if b.closed || b.messages.Len() <= 0 {
+			b.replaying = false
+			if !b.pending() {
+				b.markEmpty()
+			}
+			b.lock.Unlock()
+			for _, eachErr := range errs {
+				b.notifyError(eachErr)
+			}
+			return
+		}
+		msg := b.messages.Front().Value.(*bufferedPublishing)
+		b.lock.Unlock()
+		// the handler may publish through the service, so it is invoked without lock held
+		for _, eachErr := range errs {
+			b.notifyError(eachErr)
+		}
+
+		ctx, cancelFunc := context.WithTimeout(context.Background(), _defaultTimeout)
+		_, err := b.client.PublishWithPoolResult(ctx, msg.Exchange, msg.Key, msg.Mandatory, msg.Immediate, msg.Confirm, msg.Publishing)
+		cancelFunc()
+		if err != nil && b.isConnectionLost(err) {
+			select {
+			case <-b.connectedCh:
+			case <-time.After(interval):
+				interval *= 2
+				if interval > b.client.maxReconnectInterval {
+					interval = b.client.maxReconnectInterval
+				}
+			}
+			continue
+		}
+		interval = b.client.reconnectInterval
+		if err != nil {
+			b.notifyError(fmt.Errorf("cannot replay buffered message %s, %w", msg.Publishing.MessageId, err))
+		}
+		b.remove(msg)
+	}
+}
+
+// remove msg from the head, it may be dropped by OverflowPolicy_DropOldest already
+func (b *publishBuffer) remove(msg *bufferedPublishing) {
+	b.lock.Lock()
+	front := b.messages.Front()
+	if front == nil || front.Value.(*bufferedPublishing) != msg {
+		b.lock.Unlock()
+		return
+	}
+	b.messages.Remove(front)
+	errs := b.refill()
+	if !b.closed {
+		close(b.spaceCh)
+		b.spaceCh = make(chan struct{})
+	}
+	if !b.pending() {
+		b.markEmpty()
+	}
+	b.lock.Unlock()
+	for _, eachErr := range errs {
+		b.notifyError(eachErr)
+	}
+}
+
+// move spilled messages to memory, must be called with lock held.
+// returns the errors of reading spill file, they should be notified after lock released
+func (b *publishBuffer) refill() []error {
+	if b.spill == nil {
+		return nil
+	}
+	var errs []error
+	for b.spill.count > 0 && b.messages.Len() < b.options.Capacity {
+		msg, err := b.spill.next()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot read spill file %s, %w", b.options.SpillFile, err))
+			continue
+		}
+		b.messages.PushBack(msg)
+	}
+	return errs
+}
+
+// must be called with lock held
+func (b *publishBuffer) pending() bool {
+	return b.messages.Len() > 0 || (b.spill != nil && b.spill.count > 0)
+}
+
+// close emptyCh if not closed, and truncate spill file because all messages are replayed.
+// must be called with lock held
+func (b *publishBuffer) markEmpty() {
+	if b.spill != nil && !b.closed {
+		err := b.spill.truncate()
+		if err != nil {
+			fmt.Printf("publishBuffer.markEmpty cannot truncate spill file %s, err: %v", b.options.SpillFile, err)
+		}
+	}
+	select {
+	case <-b.emptyCh:
+	default:
+		close(b.emptyCh)
+	}
+}
+
+// recreate emptyCh when the buffer becomes non-empty, must be called with lock held
+func (b *publishBuffer) markPending() {
+	select {
+	case <-b.emptyCh:
+		b.emptyCh = make(chan struct{})
+	default:
+	}
+}
+
+func (b *publishBuffer) notifyError(err error) {
+	if b.options.ErrorHandler == nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Printf("publishBuffer.notifyError panic when notify error handler, panic: %v", p)
+		}
+	}()
+	b.options.ErrorHandler(err)
+}
+
+// is the publishing failed because the connection is lost, so the message should be buffered.
+// when the socket just died, the raw network error is returned before the connection is marked closed
+func isConnectionLost(err error) bool {
+	if errors.Is(err, ErrNotConnected) || errors.Is(err, amqp.ErrClosed) {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		// context.DeadlineExceeded implements net.Error too
+		return false
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	// the socket error is reported as a frame error when the connection is shut down
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		return amqpErr.Code == amqp.FrameError || amqpErr.Code == amqp.ConnectionForced
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// is the publishing failed because the publishing connection is lost
+func (b *publishBuffer) isConnectionLost(err error) bool {
+	return isConnectionLost(err) || !b.client.publishConn.connected()
+}
+
+// spillFile is a append-only file queue, each line is a json encoded message
+type spillFile struct {
+	writer *os.File
+	reader *os.File
+	buf    *bufio.Reader
+	// number of messages not read
+	count int
+}
+
+func openSpillFile(path string) (*spillFile, error) {
+	writer, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := os.Open(path)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	f := &spillFile{
+		writer: writer,
+		reader: reader,
+		buf:    bufio.NewReader(reader),
+	}
+	// the messages left by last process
+	scanner := bufio.NewScanner(writer)
+	scanner.Buffer(make([]byte, 64*1024), 128*1024*1024)
+	for scanner.Scan() {
+		f.count++
+	}
+	if err := scanner.Err(); err != nil {
+		f.close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *spillFile) append(msg *bufferedPublishing) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = f.writer.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	f.count++
+	return nil
+}
+
+// read the next message, the file is kept until truncated after all messages are replayed
+//
+// the message is consumed even if it cannot be read, so a broken line does not block the others
+func (f *spillFile) next() (*bufferedPublishing, error) {
+	f.count--
+	if f.count < 0 {
+		f.count = 0
+	}
+	line, err := f.buf.ReadBytes('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && len(line) > 0) {
+		return nil, err
+	}
+	msg := &bufferedPublishing{}
+	err = json.Unmarshal(line, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (f *spillFile) truncate() error {
+	err := f.writer.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = f.reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	f.buf.Reset(f.reader)
+	return nil
+}
+
+func (f *spillFile) close() error {
+	return errors.Join(f.writer.Close(), f.reader.Close())
+}
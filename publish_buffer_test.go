@@ -0,0 +1,186 @@
+package amqpx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestIsConnectionLost(t *testing.T) {
+	brokenPipe := &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}
+	lost := []error{
+		ErrNotConnected,
+		fmt.Errorf("%w, dial error", ErrNotConnected),
+		amqp.ErrClosed,
+		brokenPipe,
+		io.EOF,
+		syscall.ECONNRESET,
+		&amqp.Error{Code: amqp.FrameError, Reason: "EOF"},
+	}
+	for _, eachErr := range lost {
+		if !isConnectionLost(eachErr) {
+			t.Errorf("expect connection lost, %v", eachErr)
+		}
+	}
+	notLost := []error{
+		ErrNacked,
+		context.DeadlineExceeded,
+		context.Canceled,
+		&amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no exchange"},
+		errors.New("argument msg.Body is empty"),
+	}
+	for _, eachErr := range notLost {
+		if isConnectionLost(eachErr) {
+			t.Errorf("expect not connection lost, %v", eachErr)
+		}
+	}
+}
+
+func TestPublishBuffer_BufferWhenConnectionDropped(t *testing.T) {
+	broker := newFakeBroker(t)
+	client := newTestClient(t, broker, WithReconnectBackoff(100*time.Millisecond, 100*time.Millisecond))
+	var bufferErrs atomic.Int64
+	service := NewAMQPService(client, WithPublishBuffer(&PublishBufferOptions{
+		ErrorHandler: func(err error) {
+			bufferErrs.Add(1)
+		},
+	}))
+	err := service.QueueDeclare(QueueDeclare{Name: "orders", Durable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var consumed atomic.Int64
+	_, err = service.SimpleConsume("orders", "", func(msg *DeliveryMessage) {
+		consumed.Add(1)
+		msg.Ack(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker.dropConnections()
+	buffered := int64(0)
+	for i := 0; i < 20; i++ {
+		result, err := service.PublishWithResult(i, WithKey("orders"))
+		if err != nil {
+			t.Fatalf("expect the publishing buffered when the connection is lost, got %v", err)
+		}
+		if result.Buffered {
+			buffered++
+		}
+	}
+	if buffered <= 0 {
+		t.Fatal("expect some publishings buffered")
+	}
+	// messages written before the drop is detected may be lost, but the buffered ones are replayed
+	waitFor(t, 5*time.Second, func() bool {
+		return consumed.Load() >= buffered
+	})
+	if bufferErrs.Load() > 0 {
+		t.Fatalf("expect no buffer error, got %d", bufferErrs.Load())
+	}
+}
+
+func writeSpillFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := t.TempDir() + "/spill.jsonl"
+	data := ""
+	for _, eachLine := range lines {
+		data += eachLine + "\n"
+	}
+	err := os.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func spilledLine(t *testing.T, key string, body string) string {
+	t.Helper()
+	data, err := json.Marshal(&bufferedPublishing{
+		Key:        key,
+		Publishing: amqp.Publishing{Body: []byte(body)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPublishBuffer_KeepSpillFileUntilReplayed(t *testing.T) {
+	broker := newFakeBroker(t)
+	broker.close()
+	// never connected, so nothing is replayed
+	client := newTestClient(t, broker)
+	path := writeSpillFile(t, spilledLine(t, "orders", "1"), spilledLine(t, "orders", "2"))
+	buffer, err := newPublishBuffer(client, &PublishBufferOptions{Capacity: 2, SpillFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = buffer.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the messages moved to memory are still in file after closed
+	spill, err := openSpillFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spill.close()
+	if spill.count != 2 {
+		t.Fatalf("expect 2 messages kept in spill file, got %d", spill.count)
+	}
+}
+
+func TestPublishBuffer_NotifyErrorWithoutLock(t *testing.T) {
+	broker := newFakeBroker(t)
+	client := newTestClient(t, broker)
+	err := client.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.QueueDeclare(QueueDeclare{Name: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeSpillFile(t, spilledLine(t, "orders", "1"), "broken", spilledLine(t, "orders", "2"))
+	var service *amqpService
+	var notified atomic.Int64
+	service = NewAMQPService(client, WithPublishBuffer(&PublishBufferOptions{
+		Capacity:  1,
+		SpillFile: path,
+		ErrorHandler: func(err error) {
+			notified.Add(1)
+			// the handler may use the service, it deadlocks if invoked with lock held
+			service.publishBuffer.hasPending()
+		},
+	})).(*amqpService)
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFunc()
+	err = service.publishBuffer.waitFlushed(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notified.Load() != 1 {
+		t.Fatalf("expect the broken line notified once, got %d", notified.Load())
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("expect spill file truncated after replayed, got %d bytes", info.Size())
+	}
+}
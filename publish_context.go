@@ -3,6 +3,8 @@ package amqpx
 import (
 	"context"
 	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 const (
@@ -24,6 +26,24 @@ type PublishContext struct {
 	key       string
 	mandatory bool
 	immediate bool
+	// wait for publisher confirm
+	confirm bool
+	// amqp.Transient or amqp.Persistent
+	deliveryMode uint8
+
+	// generated by messageIdGenerator when empty
+	messageId          string
+	messageIdGenerator func() string
+	// default is now
+	timestamp time.Time
+	// default is the app id of service
+	appId string
+	// do not stamp message id, timestamp and app id
+	disableStamping bool
+	// receive the result of publishing
+	result *PublishResult
+	// check the queue exists before SendToQueue
+	verifyQueue bool
 
 	// marshal func
 	Marshal MarshalFunc
@@ -48,6 +68,11 @@ func (c *PublishContext) Key() string {
 	return c.key
 }
 
+// the message id of publishing, empty if stamping is disabled
+func (c *PublishContext) MessageId() string {
+	return c.messageId
+}
+
 func WithContext(ctx context.Context, cancelFunc context.CancelFunc) PublishOption {
 	return func(c *PublishContext) {
 		c.ctx = ctx
@@ -78,3 +103,91 @@ func WithImmediate(immediate bool) PublishOption {
 		c.immediate = immediate
 	}
 }
+
+// publish through a confirm mode channel and wait until the broker confirms the message
+func WithConfirm(confirm bool) PublishOption {
+	return func(c *PublishContext) {
+		c.confirm = confirm
+	}
+}
+
+// mark the message persistent, so that it survives broker restart when it is routed to a durable queue
+func WithPersistent(persistent bool) PublishOption {
+	return func(c *PublishContext) {
+		if persistent {
+			c.deliveryMode = amqp.Persistent
+		} else {
+			c.deliveryMode = amqp.Transient
+		}
+	}
+}
+
+// set message id instead of generating one
+func WithMessageId(messageId string) PublishOption {
+	return func(c *PublishContext) {
+		c.messageId = messageId
+	}
+}
+
+// generate message id by fn instead of the generator of service
+func WithMessageIdGenerator(fn func() string) PublishOption {
+	return func(c *PublishContext) {
+		c.messageIdGenerator = fn
+	}
+}
+
+// set timestamp instead of now
+func WithTimestamp(timestamp time.Time) PublishOption {
+	return func(c *PublishContext) {
+		c.timestamp = timestamp
+	}
+}
+
+// set app id instead of the app id of service
+func WithAppId(appId string) PublishOption {
+	return func(c *PublishContext) {
+		c.appId = appId
+	}
+}
+
+// do not stamp message id, timestamp and app id, only the values set explicitly are published
+func WithoutStamping() PublishOption {
+	return func(c *PublishContext) {
+		c.disableStamping = true
+	}
+}
+
+// receive the result of publishing, such as the generated message id
+func WithPublishResult(result *PublishResult) PublishOption {
+	return func(c *PublishContext) {
+		c.result = result
+	}
+}
+
+// fill message id, timestamp and app id when they are not set
+func (c *PublishContext) stamp(messageIdGenerator func() string, appId string) {
+	if c.disableStamping {
+		return
+	}
+	if c.messageId == "" {
+		if c.messageIdGenerator != nil {
+			messageIdGenerator = c.messageIdGenerator
+		}
+		if messageIdGenerator != nil {
+			c.messageId = messageIdGenerator()
+		}
+	}
+	if c.timestamp.IsZero() {
+		c.timestamp = time.Now()
+	}
+	if c.appId == "" {
+		c.appId = appId
+	}
+}
+
+// check the queue exists by passive declare before SendToQueue, ErrQueueNotFound is returned if not exists
+func WithVerifyQueue(verify bool) PublishOption {
+	return func(c *PublishContext) {
+		c.verifyQueue = verify
+	}
+}
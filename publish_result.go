@@ -0,0 +1,132 @@
+package amqpx
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// PublishResult the result of publishing
+type PublishResult struct {
+	// message id published, empty if stamping is disabled and no id set
+	MessageId string
+	// delivery tag on the publishing channel, 0 if not in confirm mode
+	DeliveryTag uint64
+	// acked by broker, always false if not in confirm mode
+	Confirmed bool
+	// duration from publishing to confirm received, 0 if not in confirm mode
+	ConfirmLatency time.Duration
+	// returned by broker because it is unroutable, only detected in confirm mode with mandatory
+	Returned bool
+	// the returned message, nil if not returned
+	Return *amqp.Return
+	// held in publish buffer because the connection is lost, it is published after reconnected.
+	// other fields are not set except MessageId
+	Buffered bool
+}
+
+// the confirm of a message and the return before it
+type publishConfirm struct {
+	ack bool
+	ret *amqp.Return
+}
+
+// confirmTracker resolve the confirms and returns of a confirm mode channel.
+//
+// returns and confirms are received in one goroutine. the library dispatches basic.return
+// before basic.ack of the same message, and the return is handed over through an unbuffered channel,
+// so the return is recorded before the confirm is received.
+// a pooled channel has at most one outstanding message, so the return belongs to the next confirm
+type confirmTracker struct {
+	client *AMQPClient
+	// the return not attached to a confirm yet
+	ret *amqp.Return
+	// confirm results by delivery tag, created by whichever of the publisher and the receiver comes first
+	results map[uint64]chan *publishConfirm
+	// closed when the channel is closed
+	done chan struct{}
+	// protect fields above
+	lock sync.Mutex
+}
+
+func newConfirmTracker(client *AMQPClient, ch *amqp.Channel) *confirmTracker {
+	t := &confirmTracker{
+		client:  client,
+		results: make(map[uint64]chan *publishConfirm),
+		done:    make(chan struct{}),
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return))
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	go t.receive(returns, confirms)
+	return t
+}
+
+// wait the confirm of deliveryTag, amqp.ErrClosed is returned when the channel is closed before confirmed
+func (t *confirmTracker) wait(ctx context.Context, deliveryTag uint64) (*publishConfirm, error) {
+	result := t.result(deliveryTag)
+	defer t.remove(deliveryTag)
+	select {
+	case confirm := <-result:
+		return confirm, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.done:
+		select {
+		case confirm := <-result:
+			return confirm, nil
+		default:
+			return nil, amqp.ErrClosed
+		}
+	}
+}
+
+func (t *confirmTracker) receive(returns chan amqp.Return, confirms chan amqp.Confirmation) {
+	defer close(t.done)
+	for returns != nil || confirms != nil {
+		select {
+		case eachReturn, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			t.lock.Lock()
+			t.ret = &eachReturn
+			t.lock.Unlock()
+			t.client.publishEvent(Event_PublishReturned, &PublishReturnedEvent{
+				Return: eachReturn,
+			})
+		case eachConfirm, ok := <-confirms:
+			if !ok {
+				confirms = nil
+				continue
+			}
+			t.lock.Lock()
+			ret := t.ret
+			t.ret = nil
+			t.lock.Unlock()
+			t.result(eachConfirm.DeliveryTag) <- &publishConfirm{
+				ack: eachConfirm.Ack,
+				ret: ret,
+			}
+		}
+	}
+}
+
+func (t *confirmTracker) result(deliveryTag uint64) chan *publishConfirm {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	result, ok := t.results[deliveryTag]
+	if !ok {
+		result = make(chan *publishConfirm, 1)
+		t.results[deliveryTag] = result
+	}
+	return result
+}
+
+func (t *confirmTracker) remove(deliveryTag uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.results, deliveryTag)
+}
@@ -0,0 +1,46 @@
+package amqpx
+
+// BlockedPolicy define how to publish when connection is blocked by broker
+type BlockedPolicy int
+
+const (
+	// wait until connection is unblocked or the publish context is done
+	BlockedPolicy_Wait BlockedPolicy = iota
+	// return ErrBlocked immediately
+	BlockedPolicy_FailFast
+)
+
+// ServiceOption used to configure IAMQPService
+type ServiceOption func(s *amqpService)
+
+// set how to publish when connection is blocked by broker, default is BlockedPolicy_Wait
+func WithBlockedPolicy(policy BlockedPolicy) ServiceOption {
+	return func(s *amqpService) {
+		s.blockedPolicy = policy
+	}
+}
+
+// generate message id by fn when publishing, default is NewUUIDv7
+func WithServiceMessageIdGenerator(fn func() string) ServiceOption {
+	return func(s *amqpService) {
+		if fn != nil {
+			s.messageIdGenerator = fn
+		}
+	}
+}
+
+// set app id stamped on published messages, default is the name of executable
+func WithServiceAppId(appId string) ServiceOption {
+	return func(s *amqpService) {
+		s.appId = appId
+	}
+}
+
+// hold messages in a buffer while the publishing connection is lost, and replay them in order once reconnected.
+// a buffered publishing returns nil error with PublishResult.Buffered set, see PublishBufferOptions.
+// a publishing with confirm is never buffered, it returns ErrNotConnected while the connection is lost
+func WithPublishBuffer(options *PublishBufferOptions) ServiceOption {
+	return func(s *amqpService) {
+		s.publishBufferOptions = options
+	}
+}
@@ -0,0 +1,67 @@
+package amqpx
+
+// SubscribeOptions configure subscriber created by Subscribe
+type SubscribeOptions struct {
+	// name of the durable queue bound to exchange, subscribers with the same queue share messages.
+	// if empty, a server-named exclusive queue is declared and deleted when the connection closed
+	Queue string
+	// queue arguments, such as x-message-ttl or x-queue-type
+	QueueArgs map[string]interface{}
+	// consumer tag, default is generated by GenerateUniqueConsumerName
+	Consumer string
+
+	// kind of the exchange declared if not exists, default is Exchange_Topic
+	ExchangeKind ExchangeKind
+	// is the exchange declared durable, default is true
+	ExchangeDurable bool
+
+	// options of the underlying consumer, such as prefetch and concurrency
+	ConsumeOptions []ConsumeOption
+}
+
+// SubscribeOption used to configure subscriber
+type SubscribeOption func(o *SubscribeOptions)
+
+func newDefaultSubscribeOptions() *SubscribeOptions {
+	return &SubscribeOptions{
+		ExchangeKind:    Exchange_Topic,
+		ExchangeDurable: true,
+	}
+}
+
+// consume from the named durable queue instead of a server-named exclusive queue,
+// so that the messages are kept when subscriber is offline and shared by instances of a service
+func WithSubscribeQueue(queue string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Queue = queue
+	}
+}
+
+// set arguments of the queue declared by subscriber
+func WithSubscribeQueueArgs(args map[string]interface{}) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.QueueArgs = args
+	}
+}
+
+// set consumer tag of subscriber
+func WithSubscribeConsumer(consumer string) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.Consumer = consumer
+	}
+}
+
+// set kind and durable of the exchange declared by subscriber
+func WithSubscribeExchange(kind ExchangeKind, durable bool) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.ExchangeKind = kind
+		o.ExchangeDurable = durable
+	}
+}
+
+// set options of the underlying consumer
+func WithConsumeOptions(opts ...ConsumeOption) SubscribeOption {
+	return func(o *SubscribeOptions) {
+		o.ConsumeOptions = append(o.ConsumeOptions, opts...)
+	}
+}
@@ -0,0 +1,106 @@
+package amqpx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSOptions configure TLS connection and client certificate authentication,
+// it can be loaded from configuration through configurationx.
+//
+// certificates can be supplied as file paths or PEM strings, PEM string takes precedence
+type TLSOptions struct {
+	// CA bundle used to verify server certificate, if empty the system roots are used
+	CAFile string `mapstructure:"caFile"`
+	CAPem  string `mapstructure:"caPem"`
+
+	// client certificate and private key
+	CertFile string `mapstructure:"certFile"`
+	KeyFile  string `mapstructure:"keyFile"`
+	CertPem  string `mapstructure:"certPem"`
+	KeyPem   string `mapstructure:"keyPem"`
+
+	// used to verify the hostname on the server certificate, default is the host in url
+	ServerName string `mapstructure:"serverName"`
+	// minimum TLS version, can be 1.0, 1.1, 1.2 or 1.3, default is 1.2
+	MinVersion string `mapstructure:"minVersion"`
+	// skip server certificate verification, only use it for testing
+	InsecureSkipVerify bool `mapstructure:"insecureSkipVerify"`
+
+	// authenticate with the client certificate by EXTERNAL SASL mechanism
+	// instead of the username and password in url
+	ExternalAuth bool `mapstructure:"externalAuth"`
+}
+
+// build *tls.Config from options
+func (o *TLSOptions) BuildTLSConfig() (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(o.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		MinVersion:         minVersion,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	caPem, err := readPem(o.CAPem, o.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ca certificate, %w", err)
+	}
+	if len(caPem) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("no valid ca certificate found")
+		}
+	}
+
+	certPem, err := readPem(o.CertPem, o.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read client certificate, %w", err)
+	}
+	keyPem, err := readPem(o.KeyPem, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read client private key, %w", err)
+	}
+	if len(certPem) > 0 || len(keyPem) > 0 {
+		cert, err := tls.X509KeyPair(certPem, keyPem)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate, %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if o.ExternalAuth && len(config.Certificates) <= 0 {
+		return nil, fmt.Errorf("client certificate is required when ExternalAuth is true")
+	}
+	return config, nil
+}
+
+func readPem(pem string, file string) ([]byte, error) {
+	if pem != "" {
+		return []byte(pem), nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return os.ReadFile(file)
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimSpace(version) {
+	case "":
+		return tls.VersionTLS12, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported tls version: %s", version)
+}
@@ -1,40 +1,129 @@
 package amqpx
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 // IConsumer define a amqp queue consumer
 type ITopicConsumer interface {
-	// observe a message
-	Observe(fn func(msg *DeliveryMessage))
+	// observe a message, returns the handle used to remove the observer
+	Observe(fn func(msg *DeliveryMessage)) ObserveHandle
+	// remove a observer by the handle returned by Observe,
+	// the observer passed to SimpleConsume or Subscribe is InitialObserveHandle
+	Unobserve(handle ObserveHandle)
 
-	// stop consume
+	// pause consume, the consumer is cancelled on server and the deliveries in flight are still handled
+	Pause() error
+	// resume consume with the same consumer tag
+	Resume() error
+
+	// closed when the consume loop exited
+	Done() <-chan struct{}
+
+	// stop consume, it waits for the in-flight handlers finished and releases the channel.
+	// do not call it in observer, because it waits for the observers
 	Stop() error
 }
 
+// ObserveHandle identify a observer registed on consumer
+type ObserveHandle uint64
+
+// the handle of the observer passed to SimpleConsume or Subscribe
+const InitialObserveHandle ObserveHandle = 1
+
+type registedObserver struct {
+	handle ObserveHandle
+	fn     func(msg *DeliveryMessage)
+}
+
+// subscribe topic, returns the channel and deliveries
+type subscribeFunc func() (*amqp.Channel, <-chan amqp.Delivery, error)
+
 type defaultTopicConsumer struct {
 	consumer string
+	topic    string
 	channel  *amqp.Channel
 	ch       <-chan amqp.Delivery
+	// protect channel and ch, they are replaced when resubscribe
+	lock sync.Mutex
+
+	options   *ConsumeOptions
+	subscribe subscribeFunc
 
 	unmarshal       func([]byte, interface{}) error
-	registedObserve []func(msg *DeliveryMessage)
+	registedObserve []registedObserver
+	// the handle of the last registed observer
+	lastObserveHandle ObserveHandle
+	observeLock       sync.RWMutex
+
+	// closed when consume loop exited
+	doneCh chan struct{}
+	// stopped by Stop
+	stopped atomic.Bool
+	// closed by Stop
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	// cancelled by client, used to distinguish from server cancel
+	cancelling atomic.Bool
+	// closed by cancel or stop, so the paused consume loop exits
+	cancelCh   chan struct{}
+	cancelOnce sync.Once
+	// paused by Pause, protected by lock
+	paused bool
+	// signal the paused consume loop to continue
+	resumeCh chan struct{}
+	// signal the consume loop waiting for resubscribe that the connection is recovered
+	reconnectedCh chan struct{}
+	// release resources, such as channel, after stopped
+	release     func(channel *amqp.Channel)
+	releaseOnce sync.Once
+	// unix nano of the last delivery
+	lastConsumeAt atomic.Int64
+}
+
+// consumer managed by service, it is drained when service shutdown and reported by health
+type managedConsumer interface {
+	// cancel the consumer and wait for cancel-ok, the deliveries in flight are still handled
+	cancel() error
+	// closed when the consume loop exited and all handlers are finished
+	Done() <-chan struct{}
+	health() ConsumerHealth
 }
 
 var _ ITopicConsumer = (*defaultTopicConsumer)(nil)
+var _ managedConsumer = (*defaultTopicConsumer)(nil)
 
 func newDefaultConsumer(consumer string,
+	topic string,
 	channel *amqp.Channel,
 	ch <-chan amqp.Delivery,
-	observeFn func(msg *DeliveryMessage)) *defaultTopicConsumer {
+	observeFn func(msg *DeliveryMessage),
+	options *ConsumeOptions,
+	subscribe subscribeFunc,
+	release func(channel *amqp.Channel)) *defaultTopicConsumer {
+	if options == nil {
+		options = newDefaultConsumeOptions()
+	}
 	defaultConsumer := &defaultTopicConsumer{
-		consumer:  consumer,
-		channel:   channel,
-		ch:        ch,
-		unmarshal: _unmarshal,
+		consumer:      consumer,
+		topic:         topic,
+		channel:       channel,
+		ch:            ch,
+		options:       options,
+		subscribe:     subscribe,
+		unmarshal:     _unmarshal,
+		doneCh:        make(chan struct{}),
+		stopCh:        make(chan struct{}),
+		cancelCh:      make(chan struct{}),
+		resumeCh:      make(chan struct{}, 1),
+		reconnectedCh: make(chan struct{}, 1),
+		release:       release,
 	}
 
 	if observeFn != nil {
@@ -46,35 +135,299 @@ func newDefaultConsumer(consumer string,
 
 // #region IConsumer Members
 
-func (c *defaultTopicConsumer) Observe(fn func(msg *DeliveryMessage)) {
-	c.registedObserve = append(c.registedObserve, fn)
+func (c *defaultTopicConsumer) Observe(fn func(msg *DeliveryMessage)) ObserveHandle {
+	c.observeLock.Lock()
+	defer c.observeLock.Unlock()
+	c.lastObserveHandle++
+	c.registedObserve = append(c.registedObserve, registedObserver{
+		handle: c.lastObserveHandle,
+		fn:     fn,
+	})
+	return c.lastObserveHandle
 }
 
-func (c *defaultTopicConsumer) Stop() error {
-	err := c.channel.Cancel(c.consumer, true)
+func (c *defaultTopicConsumer) Unobserve(handle ObserveHandle) {
+	c.observeLock.Lock()
+	defer c.observeLock.Unlock()
+	for i, eachObserve := range c.registedObserve {
+		if eachObserve.handle == handle {
+			c.registedObserve = append(c.registedObserve[:i:i], c.registedObserve[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *defaultTopicConsumer) Pause() error {
+	if c.stopped.Load() {
+		return fmt.Errorf("consumer %s is stopped", c.consumer)
+	}
+	c.lock.Lock()
+	if c.paused {
+		c.lock.Unlock()
+		return nil
+	}
+	c.paused = true
+	channel := c.channel
+	c.lock.Unlock()
+
+	err := channel.Cancel(c.consumer, false)
+	if err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func (c *defaultTopicConsumer) Resume() error {
+	if c.stopped.Load() || c.cancelling.Load() {
+		return fmt.Errorf("consumer %s is stopped", c.consumer)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.paused {
+		return nil
+	}
+	if c.subscribe == nil {
+		return fmt.Errorf("consumer %s cannot be resumed", c.consumer)
+	}
+	channel, ch, err := c.subscribe()
 	if err != nil {
 		return err
 	}
+	c.channel = channel
+	c.ch = ch
+	c.paused = false
+	select {
+	case c.resumeCh <- struct{}{}:
+	default:
+	}
 	return nil
 }
 
+func (c *defaultTopicConsumer) Done() <-chan struct{} {
+	return c.doneCh
+}
+
+func (c *defaultTopicConsumer) Stop() error {
+	err := c.stop()
+	<-c.doneCh
+	// the consume loop may exit by itself before stopped, such as cancelled by server
+	c.releaseChannel()
+	return err
+}
+
 // #endregion
 
+// stop consume without waiting for the consume loop exited
+func (c *defaultTopicConsumer) stop() error {
+	c.stopped.Store(true)
+	c.cancelling.Store(true)
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+	})
+	channel, _ := c.current()
+	// wait for cancel-ok, so the deliveries in flight are still handled
+	err := channel.Cancel(c.consumer, false)
+	if err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func (c *defaultTopicConsumer) cancel() error {
+	c.cancelling.Store(true)
+	c.cancelOnce.Do(func() {
+		close(c.cancelCh)
+	})
+	channel, _ := c.current()
+	err := channel.Cancel(c.consumer, false)
+	if err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func (c *defaultTopicConsumer) health() ConsumerHealth {
+	return newConsumerHealth(c.consumer, c.topic, c.Done(), c.stopped.Load(), c.lastConsumeAt.Load())
+}
+
 func (c *defaultTopicConsumer) start() {
 	go c.safeStart()
 }
 
 func (c *defaultTopicConsumer) safeStart() {
+	defer func() {
+		if c.stopped.Load() {
+			c.releaseChannel()
+		}
+		close(c.doneCh)
+	}()
+	for {
+		channel, ch := c.current()
+		c.consume(ch)
+		if c.waitResume(ch) {
+			continue
+		}
+		if c.isChannelLost(channel) {
+			// the channel or connection is lost, resubscribe once the connection recovered
+			if !c.resubscribe() {
+				return
+			}
+			continue
+		}
+		if !c.isCancelledByServer(channel) {
+			return
+		}
+		c.notifyError(fmt.Errorf("%w, consumer: %s, topic: %s", ErrConsumerCancelled, c.consumer, c.topic))
+		if !c.options.Resubscribe || !c.resubscribe() {
+			return
+		}
+	}
+}
+
+// release the channel once, however the consume loop exited
+func (c *defaultTopicConsumer) releaseChannel() {
+	if c.release == nil {
+		return
+	}
+	c.releaseOnce.Do(func() {
+		channel, _ := c.current()
+		c.release(channel)
+	})
+}
+
+// consume deliveries by options.Concurrency goroutines, returns when deliveries closed and all handled
+func (c *defaultTopicConsumer) consume(ch <-chan amqp.Delivery) {
+	concurrency := c.options.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			c.safeConsume(ch)
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *defaultTopicConsumer) safeConsume(ch <-chan amqp.Delivery) {
 	defer func() {
 		if p := recover(); p != nil {
-			c.Stop()
+			c.stop()
 		}
 	}()
-	for eachDelivery := range c.ch {
+	for eachDelivery := range ch {
+		c.lastConsumeAt.Store(time.Now().UnixNano())
 		c.notifyObserver(&eachDelivery)
 	}
 }
 
+func (c *defaultTopicConsumer) current() (*amqp.Channel, <-chan amqp.Delivery) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.channel, c.ch
+}
+
+// wait until resumed when paused, returns false when not paused or stopped.
+// consumed parameter is the deliveries just consumed
+func (c *defaultTopicConsumer) waitResume(consumed <-chan amqp.Delivery) bool {
+	for {
+		c.lock.Lock()
+		// already resumed
+		replaced := c.ch != consumed
+		paused := c.paused
+		c.lock.Unlock()
+		if replaced {
+			return true
+		}
+		if !paused {
+			return false
+		}
+		select {
+		case <-c.resumeCh:
+		case <-c.stopCh:
+			return false
+		case <-c.cancelCh:
+			return false
+		}
+	}
+}
+
+// deliveries are closed because the channel is closed, but the consumer is not cancelled by client
+func (c *defaultTopicConsumer) isChannelLost(channel *amqp.Channel) bool {
+	return !c.cancelling.Load() && channel.IsClosed()
+}
+
+// signal the consume loop waiting for resubscribe that the connection is recovered
+func (c *defaultTopicConsumer) reconnected() {
+	select {
+	case c.reconnectedCh <- struct{}{}:
+	default:
+	}
+}
+
+// deliveries are closed but neither the consumer is cancelled by client nor the channel is closed,
+// so it must be cancelled by server
+func (c *defaultTopicConsumer) isCancelledByServer(channel *amqp.Channel) bool {
+	return !c.cancelling.Load() && !channel.IsClosed()
+}
+
+// resubscribe with backoff until success or stopped, returns false when stopped.
+// it retries immediately when the connection is recovered
+func (c *defaultTopicConsumer) resubscribe() bool {
+	if c.subscribe == nil {
+		return false
+	}
+	interval := c.options.ResubscribeBackoff
+	for {
+		select {
+		case <-c.stopCh:
+			return false
+		case <-c.reconnectedCh:
+		case <-time.After(interval):
+		}
+		if c.cancelling.Load() {
+			// cancelled by client while waiting
+			return false
+		}
+		channel, ch, err := c.subscribe()
+		if errors.Is(err, ErrShutdown) {
+			return false
+		}
+		if err != nil {
+			c.notifyError(fmt.Errorf("cannot resubscribe topic %s, %w", c.topic, err))
+			interval *= 2
+			if interval > _defaultMaxReconnectInterval {
+				interval = _defaultMaxReconnectInterval
+			}
+			continue
+		}
+		c.lock.Lock()
+		c.channel = channel
+		c.ch = ch
+		c.lock.Unlock()
+		if c.cancelling.Load() {
+			// stopped while resubscribing
+			channel.Cancel(c.consumer, false)
+		}
+		return true
+	}
+}
+
+func (c *defaultTopicConsumer) notifyError(err error) {
+	if c.options.ErrorHandler == nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			fmt.Printf("defaultConsumer.notifyError panic when notify error handler, panic: %v", p)
+		}
+	}()
+	c.options.ErrorHandler(c.consumer, err)
+}
+
 func (c *defaultTopicConsumer) notifyObserver(deliveryMessage *amqp.Delivery) {
 	defer func() {
 		if p := recover(); p != nil {
@@ -82,12 +435,14 @@ func (c *defaultTopicConsumer) notifyObserver(deliveryMessage *amqp.Delivery) {
 		}
 	}()
 
-	clonedObserver := make([]func(msg *DeliveryMessage), len(c.registedObserve))
+	c.observeLock.RLock()
+	clonedObserver := make([]registedObserver, len(c.registedObserve))
 	copy(clonedObserver, c.registedObserve)
+	c.observeLock.RUnlock()
 
 	newMessage := newDeliveryMessage(deliveryMessage)
 	newMessage.unmarshal = c.unmarshal
 	for _, eachObserver := range clonedObserver {
-		eachObserver(newMessage)
+		eachObserver.fn(newMessage)
 	}
 }
@@ -0,0 +1,57 @@
+package amqpx
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTopicConsumer_StopAfterCancelledByServer(t *testing.T) {
+	broker := newFakeBroker(t)
+	client := newTestClient(t, broker)
+	service := NewAMQPService(client)
+	err := service.QueueDeclare(QueueDeclare{Name: "orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var consumed atomic.Int64
+	observeFn := func(msg *DeliveryMessage) {
+		consumed.Add(1)
+		msg.Ack(false)
+	}
+	consumer, err := service.SimpleConsume("orders", "tag1", observeFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker.cancelConsumers("orders")
+	select {
+	case <-consumer.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect consume loop exited after cancelled by server")
+	}
+	err = consumer.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the channel is released by Stop, so the same consumer tag can consume again
+	_, err = service.SimpleConsume("orders", "tag1", observeFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.Publish("message", WithKey("orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		return consumed.Load() == 1
+	})
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFunc()
+	err = service.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,236 @@
+package amqpx
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WorkHandler handle a task of work queue, the task is acked when it returns nil,
+// otherwise it is nacked and requeued or dead-lettered according to WorkQueueOptions.RequeueOnError
+type WorkHandler func(msg *DeliveryMessage) error
+
+// IWorkQueue is a competing-consumer work queue, each task is handled by only one worker
+// among all processes consuming the queue
+type IWorkQueue interface {
+	// publish a persistent task to the queue through the default exchange
+	Enqueue(v interface{}, opts ...PublishOption) error
+	// start workers handling tasks, the number of workers is WorkQueueOptions.Concurrency
+	Work(handler WorkHandler) error
+	// statistics of each worker
+	Stats() []WorkerStats
+	// stop workers, it waits for the in-flight tasks finished
+	Stop() error
+}
+
+// WorkQueueOptions configure work queue
+type WorkQueueOptions struct {
+	// number of workers in this process, it is also the prefetch count, default is 1
+	Concurrency int
+	// requeue the task when handler failed, otherwise it is dropped or dead-lettered
+	RequeueOnError bool
+	// queue arguments, such as x-dead-letter-exchange
+	QueueArgs map[string]interface{}
+	// consumer tag, default is generated by GenerateUniqueConsumerName
+	Consumer string
+	// options of the underlying consumer, such as error handler
+	ConsumeOptions []ConsumeOption
+}
+
+// WorkQueueOption used to configure work queue
+type WorkQueueOption func(o *WorkQueueOptions)
+
+// set number of workers in this process, tasks are dispatched fairly with prefetch count equals to concurrency
+func WithWorkConcurrency(concurrency int) WorkQueueOption {
+	return func(o *WorkQueueOptions) {
+		if concurrency > 0 {
+			o.Concurrency = concurrency
+		}
+	}
+}
+
+// requeue the task when handler failed
+func WithRequeueOnError(requeue bool) WorkQueueOption {
+	return func(o *WorkQueueOptions) {
+		o.RequeueOnError = requeue
+	}
+}
+
+// set arguments of the work queue
+func WithWorkQueueArgs(args map[string]interface{}) WorkQueueOption {
+	return func(o *WorkQueueOptions) {
+		o.QueueArgs = args
+	}
+}
+
+// set consumer tag of workers
+func WithWorkConsumer(consumer string) WorkQueueOption {
+	return func(o *WorkQueueOptions) {
+		o.Consumer = consumer
+	}
+}
+
+// set options of the underlying consumer
+func WithWorkConsumeOptions(opts ...ConsumeOption) WorkQueueOption {
+	return func(o *WorkQueueOptions) {
+		o.ConsumeOptions = append(o.ConsumeOptions, opts...)
+	}
+}
+
+// WorkerStats statistics of a worker
+type WorkerStats struct {
+	Worker int
+	// is the worker handling a task
+	Busy      bool
+	Handled   uint64
+	Succeeded uint64
+	Failed    uint64
+	// nil if no task handled
+	LastHandledAt *time.Time
+}
+
+type workerStats struct {
+	busy          atomic.Bool
+	succeeded     atomic.Uint64
+	failed        atomic.Uint64
+	lastHandledAt atomic.Int64
+}
+
+type workQueue struct {
+	service IAMQPService
+	queue   string
+	options *WorkQueueOptions
+
+	consumer ITopicConsumer
+	stats    []*workerStats
+	// idle worker ids, a worker id is taken while handling a task
+	idleWorkers chan int
+	lock        sync.Mutex
+}
+
+var _ IWorkQueue = (*workQueue)(nil)
+
+// create a work queue, the durable queue is declared if not exists
+func NewWorkQueue(service IAMQPService, queue string, opts ...WorkQueueOption) (IWorkQueue, error) {
+	if queue == "" {
+		return nil, fmt.Errorf("queue can not be empty")
+	}
+	options := &WorkQueueOptions{
+		Concurrency: 1,
+	}
+	for _, eachOpt := range opts {
+		eachOpt(options)
+	}
+	err := service.QueueDeclare(QueueDeclare{
+		Name:    queue,
+		Durable: true,
+		Args:    options.QueueArgs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	q := &workQueue{
+		service:     service,
+		queue:       queue,
+		options:     options,
+		stats:       make([]*workerStats, options.Concurrency),
+		idleWorkers: make(chan int, options.Concurrency),
+	}
+	for i := range q.stats {
+		q.stats[i] = &workerStats{}
+		q.idleWorkers <- i
+	}
+	return q, nil
+}
+
+// #region IWorkQueue Members
+
+func (q *workQueue) Enqueue(v interface{}, opts ...PublishOption) error {
+	publishOpts := []PublishOption{WithExchange(""), WithKey(q.queue), WithPersistent(true)}
+	return q.service.Publish(v, append(publishOpts, opts...)...)
+}
+
+func (q *workQueue) Work(handler WorkHandler) error {
+	if handler == nil {
+		return fmt.Errorf("handler can not be nil")
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.consumer != nil {
+		return fmt.Errorf("work queue %s is already working", q.queue)
+	}
+	consumeOpts := append([]ConsumeOption{
+		WithPrefetch(q.options.Concurrency),
+		WithConcurrency(q.options.Concurrency),
+	}, q.options.ConsumeOptions...)
+	consumer, err := q.service.SimpleConsume(q.queue, q.options.Consumer, func(msg *DeliveryMessage) {
+		q.handle(handler, msg)
+	}, consumeOpts...)
+	if err != nil {
+		return err
+	}
+	q.consumer = consumer
+	return nil
+}
+
+func (q *workQueue) Stats() []WorkerStats {
+	stats := make([]WorkerStats, len(q.stats))
+	for i, eachStats := range q.stats {
+		succeeded := eachStats.succeeded.Load()
+		failed := eachStats.failed.Load()
+		stats[i] = WorkerStats{
+			Worker:        i,
+			Busy:          eachStats.busy.Load(),
+			Handled:       succeeded + failed,
+			Succeeded:     succeeded,
+			Failed:        failed,
+			LastHandledAt: unixNanoTime(eachStats.lastHandledAt.Load()),
+		}
+	}
+	return stats
+}
+
+func (q *workQueue) Stop() error {
+	q.lock.Lock()
+	consumer := q.consumer
+	q.consumer = nil
+	q.lock.Unlock()
+	if consumer == nil {
+		return nil
+	}
+	return consumer.Stop()
+}
+
+// #endregion
+
+// handle task by an idle worker, there are always idle workers because
+// the number of consuming goroutines equals to the number of workers
+func (q *workQueue) handle(handler WorkHandler, msg *DeliveryMessage) {
+	worker := <-q.idleWorkers
+	defer func() {
+		q.idleWorkers <- worker
+	}()
+	stats := q.stats[worker]
+	stats.busy.Store(true)
+	defer stats.busy.Store(false)
+
+	err := q.safeHandle(handler, msg)
+	stats.lastHandledAt.Store(time.Now().UnixNano())
+	if err != nil {
+		stats.failed.Add(1)
+		msg.Nack(false, q.options.RequeueOnError)
+		return
+	}
+	stats.succeeded.Add(1)
+	msg.Ack(false)
+}
+
+func (q *workQueue) safeHandle(handler WorkHandler, msg *DeliveryMessage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("work queue %s handler panic: %v", q.queue, p)
+		}
+	}()
+	return handler(msg)
+}